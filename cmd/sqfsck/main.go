@@ -0,0 +1,91 @@
+//Command sqfsck checks a squashfs archive for corruption and inconsistencies.
+//Every problem found is printed with it's byte offset in the archive.
+//
+//	sqfsck [options] filesystem
+//
+//The exit code is 0 if no problems are found, 4 if problems are found, and 8 if the archive can't be checked.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+
+	"github.com/CalebQ42/squashfs"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	code := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
+
+//run runs sqfsck with the given arguments and returns the exit code.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	var offset int64
+	var quiet bool
+	set := flag.NewFlagSet("sqfsck", flag.ContinueOnError)
+	set.SetOutput(stderr)
+	set.Usage = func() {
+		fmt.Fprintln(stderr, "SYNTAX: sqfsck [options] filesystem")
+		set.PrintDefaults()
+	}
+	for _, name := range []string{"o", "offset"} {
+		set.Int64Var(&offset, name, 0, "skip `bytes` at start of filesystem")
+	}
+	for _, name := range []string{"q", "quiet"} {
+		set.BoolVar(&quiet, name, false, "don't print problems, only set the exit code")
+	}
+	err := set.Parse(args)
+	if err == flag.ErrHelp {
+		return 0
+	} else if err != nil || set.NArg() != 1 {
+		if err == nil {
+			set.Usage()
+		}
+		return 8
+	}
+	findings, err := check(ctx, set.Arg(0), offset)
+	if err != nil {
+		fmt.Fprintln(stderr, "sqfsck:", err)
+		return 8
+	}
+	if len(findings) == 0 {
+		if !quiet {
+			fmt.Fprintln(stdout, set.Arg(0)+": no problems found")
+		}
+		return 0
+	}
+	if !quiet {
+		for _, f := range findings {
+			fmt.Fprintln(stdout, f)
+		}
+		fmt.Fprintf(stdout, "%s: %d problems found\n", set.Arg(0), len(findings))
+	}
+	return 4
+}
+
+func check(ctx context.Context, archive string, offset int64) ([]squashfs.Finding, error) {
+	fil, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer fil.Close()
+	var r io.ReaderAt = fil
+	if offset > 0 {
+		r = io.NewSectionReader(fil, offset, 1<<62)
+	}
+	//CheckArchive is used instead of NewReader so broken tables are reported as problems instead of stopping the check.
+	findings, err := squashfs.CheckArchive(ctx, r, squashfs.DefaultReaderOptions())
+	if errors.Is(err, squashfs.ErrorMagic) {
+		return nil, fmt.Errorf("can't find a SQUASHFS superblock on %s", archive)
+	} else if err != nil && ctx.Err() == nil {
+		return nil, fmt.Errorf("can't check %s: %w", archive, err)
+	}
+	return findings, err
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/CalebQ42/squashfs"
+)
+
+//testArchive is an archive made by mksquashfs. See testdata/mksquashfs.sh.
+var testArchive = filepath.Join("..", "..", "testdata", "mksquashfs.sqfs")
+
+//writeCorrupt writes a copy of testArchive, with prefix before it, after corrupt changes it. Returns the copy's path.
+func writeCorrupt(t *testing.T, prefix []byte, corrupt func(dat []byte, info squashfs.Info)) string {
+	t.Helper()
+	dat, err := os.ReadFile(testArchive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := squashfs.Probe(bytes.NewReader(dat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if corrupt != nil {
+		corrupt(dat, info)
+	}
+	out := filepath.Join(t.TempDir(), "corrupt.sqfs")
+	err = os.WriteFile(out, append(prefix, dat...), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestSqfsck(t *testing.T) {
+	//Makes the id table's only metadata block start past the end of the archive.
+	badIds := writeCorrupt(t, nil, func(dat []byte, info squashfs.Info) {
+		binary.LittleEndian.PutUint64(dat[info.IdTableStart:], info.Size+1000)
+	})
+	//Makes the xattr id table have more entries then can fit in the archive.
+	badXattrs := writeCorrupt(t, nil, func(dat []byte, info squashfs.Info) {
+		binary.LittleEndian.PutUint32(dat[info.XattrTableStart+8:], 0xFFFFFFFF)
+	})
+	notSquashfs := filepath.Join(t.TempDir(), "zeros")
+	err := os.WriteFile(notSquashfs, make([]byte, 4096), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		stdout []string
+		stderr string
+	}{
+		{"clean", []string{testArchive}, 0, []string{testArchive + ": no problems found"}, ""},
+		{"offset", []string{"-o", "100", writeCorrupt(t, make([]byte, 100), nil)}, 0, []string{"no problems found"}, ""},
+		{"id table", []string{badIds}, 4, []string{"can't read id table", "problems found"}, ""},
+		{"xattr table", []string{badXattrs}, 4, []string{"can't read xattr table", "problems found"}, ""},
+		{"quiet", []string{"-q", badIds}, 4, nil, ""},
+		{"not squashfs", []string{notSquashfs}, 8, nil, "can't find a SQUASHFS superblock on " + notSquashfs},
+		{"missing", []string{filepath.Join(t.TempDir(), "missing")}, 8, nil, "no such file"},
+		{"no archive", nil, 8, nil, "SYNTAX"},
+	}
+	for _, test := range tests {
+		var stdout, stderr bytes.Buffer
+		code := run(context.Background(), test.args, &stdout, &stderr)
+		if code != test.code {
+			t.Errorf("%s: exit code %d, want %d. stdout: %q stderr: %q", test.name, code, test.code, stdout.String(), stderr.String())
+		}
+		if test.stdout == nil && stdout.Len() != 0 {
+			t.Errorf("%s: unexpected output %q", test.name, stdout.String())
+		}
+		for _, want := range test.stdout {
+			if !strings.Contains(stdout.String(), want) {
+				t.Errorf("%s: output %q doesn't contain %q", test.name, stdout.String(), want)
+			}
+		}
+		if !strings.Contains(stderr.String(), test.stderr) {
+			t.Errorf("%s: error output %q doesn't contain %q", test.name, stderr.String(), test.stderr)
+		}
+	}
+}
@@ -0,0 +1,439 @@
+//Command unsquashfs extracts, lists, and describes squashfs archives.
+//It accepts the common flags of squashfs-tools' unsquashfs and matches it's output.
+//
+//	unsquashfs [options] filesystem [directories or files to extract]
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/CalebQ42/squashfs"
+)
+
+type options struct {
+	dest        string
+	extractFile string
+	offset      int64
+	procs       int
+	force       bool
+	list        bool
+	longList    bool
+	numeric     bool
+	stat        bool
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+//run runs unsquashfs with the given arguments and returns the exit code.
+func run(args []string, stdout, stderr io.Writer) int {
+	var op options
+	set := flag.NewFlagSet("unsquashfs", flag.ContinueOnError)
+	set.SetOutput(stderr)
+	set.Usage = func() {
+		fmt.Fprintln(stderr, "SYNTAX: unsquashfs [options] filesystem [directories or files to extract]")
+		set.PrintDefaults()
+	}
+	for _, name := range []string{"d", "dest"} {
+		set.StringVar(&op.dest, name, "squashfs-root", "extract to `pathname`")
+	}
+	for _, name := range []string{"f", "force"} {
+		set.BoolVar(&op.force, name, false, "if file already exists then overwrite")
+	}
+	for _, name := range []string{"l", "ls"} {
+		set.BoolVar(&op.list, name, false, "list filesystem, but don't extract files")
+	}
+	for _, name := range []string{"ll", "lls"} {
+		set.BoolVar(&op.longList, name, false, "list filesystem with file attributes, but don't extract files")
+	}
+	for _, name := range []string{"lln", "llnumeric"} {
+		set.BoolVar(&op.numeric, name, false, "same as -ll, but with numeric uids and gids")
+	}
+	for _, name := range []string{"s", "stat"} {
+		set.BoolVar(&op.stat, name, false, "display filesystem superblock information")
+	}
+	for _, name := range []string{"e", "ef"} {
+		set.StringVar(&op.extractFile, name, "", "list of directories or files to extract, one per line")
+	}
+	for _, name := range []string{"o", "offset"} {
+		set.Int64Var(&op.offset, name, 0, "skip `bytes` at start of filesystem")
+	}
+	for _, name := range []string{"p", "processors"} {
+		set.IntVar(&op.procs, name, runtime.NumCPU(), "use `number` processors")
+	}
+	//Progress bars aren't shown, so these are accepted and ignored.
+	var noProgress bool
+	for _, name := range []string{"n", "no-progress"} {
+		set.BoolVar(&noProgress, name, false, "don't display the progress bar")
+	}
+	err := set.Parse(args)
+	if err == flag.ErrHelp {
+		return 0
+	} else if err != nil {
+		return 1
+	}
+	if set.NArg() < 1 {
+		set.Usage()
+		return 1
+	}
+	if op.procs < 1 {
+		fmt.Fprintln(stderr, "unsquashfs: -processors should be 1 or larger")
+		return 1
+	}
+	paths, err := extractPaths(set.Args()[1:], op.extractFile)
+	if err != nil {
+		fmt.Fprintln(stderr, "unsquashfs:", err)
+		return 1
+	}
+	err = unsquash(set.Arg(0), paths, op, stdout)
+	if err != nil {
+		fmt.Fprintln(stderr, "unsquashfs:", err)
+		return 1
+	}
+	return 0
+}
+
+//extractPaths returns the cleaned paths from args and the lines of extractFile. If there are none, returns the root.
+func extractPaths(args []string, extractFile string) ([]string, error) {
+	paths := args
+	if extractFile != "" {
+		fil, err := os.Open(extractFile)
+		if err != nil {
+			return nil, err
+		}
+		defer fil.Close()
+		scan := bufio.NewScanner(fil)
+		for scan.Scan() {
+			if line := strings.TrimSpace(scan.Text()); line != "" {
+				paths = append(paths, line)
+			}
+		}
+		if scan.Err() != nil {
+			return nil, scan.Err()
+		}
+	}
+	if len(paths) == 0 {
+		return []string{"."}, nil
+	}
+	out := make([]string, len(paths))
+	for i := range paths {
+		out[i] = path.Clean(strings.Trim(paths[i], "/"))
+	}
+	return out, nil
+}
+
+func unsquash(archive string, paths []string, op options, out io.Writer) error {
+	fil, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer fil.Close()
+	var r io.ReaderAt = fil
+	if op.offset > 0 {
+		r = io.NewSectionReader(fil, op.offset, 1<<62)
+	}
+	if op.stat {
+		info, err := squashfs.Probe(r)
+		if err != nil {
+			return fmt.Errorf("can't find a SQUASHFS superblock on %s: %w", archive, err)
+		}
+		xattrs, err := xattrCount(r, info)
+		if err != nil {
+			return fmt.Errorf("can't read the xattr table of %s: %w", archive, err)
+		}
+		printStat(out, archive, info, xattrs)
+		return nil
+	}
+	rdr, err := squashfs.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("can't find a SQUASHFS superblock on %s: %w", archive, err)
+	}
+	defer rdr.Close()
+	if op.numeric {
+		op.longList = true
+	}
+	if op.list || op.longList {
+		return list(out, rdr, paths, op)
+	}
+	return extract(out, rdr, paths, op)
+}
+
+//walk calls fn, in order, for everything in the archive that's selected by paths, and the directories that lead to them.
+//Like unsquashfs, each element of a path can contain the wildcards of path.Match.
+func walk(rdr *squashfs.Reader, paths []string, fn func(name string, d fs.DirEntry) error) error {
+	var patterns [][]string
+	for _, p := range paths {
+		if p == "." {
+			patterns = nil
+			break
+		}
+		patterns = append(patterns, strings.Split(p, "/"))
+	}
+	return fs.WalkDir(rdr, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name == "." || patterns == nil {
+			return fn(name, d)
+		}
+		split := strings.Split(name, "/")
+		var leads bool
+		for _, pattern := range patterns {
+			n := len(split)
+			if n > len(pattern) {
+				n = len(pattern)
+			}
+			match := true
+			for i := 0; match && i < n; i++ {
+				match, _ = path.Match(pattern[i], split[i])
+			}
+			if match && len(split) >= len(pattern) {
+				return fn(name, d)
+			}
+			leads = leads || match
+		}
+		if !d.IsDir() {
+			return nil
+		} else if !leads {
+			return fs.SkipDir
+		}
+		return fn(name, d)
+	})
+}
+
+//list prints every file selected by paths, prefixed with the destination, like unsquashfs -l and -ll.
+func list(out io.Writer, rdr *squashfs.Reader, paths []string, op options) error {
+	names := newNameCache(op.numeric)
+	return walk(rdr, paths, func(name string, d fs.DirEntry) error {
+		full := op.dest
+		if name != "." {
+			full += "/" + name
+		}
+		if !op.longList {
+			_, err := fmt.Fprintln(out, full)
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		target := ""
+		if d.Type() == fs.ModeSymlink {
+			fil, err := rdr.Open(name)
+			if err != nil {
+				return err
+			}
+			target = fil.(*squashfs.File).SymlinkPath()
+			fil.Close()
+		}
+		_, err = fmt.Fprintln(out, longLine(info, full, target, names))
+		return err
+	})
+}
+
+//longLine formats a file the same as unsquashfs -ll.
+func longLine(info fs.FileInfo, name, target string, names *nameCache) string {
+	sys := info.Sys().(*squashfs.InodeInfo)
+	usr, group := names.user(sys.Uid), names.group(sys.Gid)
+	//unsquashfs pads the owner and size to a total of 25 characters, not counting the separators.
+	pad := 25 - len(usr) - len(group)
+	if pad < 0 {
+		pad = 0
+	}
+	line := modeString(sys.Type, info.Mode()) + " " + usr + "/" + group + " "
+	switch basicType(sys.Type) {
+	case squashfs.BlockType, squashfs.CharType:
+		if pad -= 7; pad < 0 {
+			pad = 0
+		}
+		//The padding is always at least one space.
+		line += fmt.Sprintf("%*s%3d,%3d ", pad, " ", sys.DevMajor, sys.DevMinor)
+	case squashfs.DirType:
+		line += fmt.Sprintf("%*d ", pad, sys.DirSize)
+	case squashfs.SymlinkType:
+		line += fmt.Sprintf("%*d ", pad, len(target))
+	default:
+		line += fmt.Sprintf("%*d ", pad, info.Size())
+	}
+	line += info.ModTime().Format("2006-01-02 15:04") + " " + name
+	if target != "" {
+		line += " -> " + target
+	}
+	return line
+}
+
+//basicType returns the basic version of an extended inode type.
+func basicType(typ uint16) uint16 {
+	if typ > squashfs.SocketType {
+		return typ - squashfs.SocketType
+	}
+	return typ
+}
+
+//modeString formats the type and permissions like ls -l.
+func modeString(typ uint16, mode fs.FileMode) string {
+	out := []byte("?rwxrwxrwx")
+	if typ = basicType(typ); typ <= squashfs.SocketType {
+		out[0] = "?d-lbcps"[typ]
+	}
+	for i := 0; i < 9; i++ {
+		if mode&(1<<(8-i)) == 0 {
+			out[i+1] = '-'
+		}
+	}
+	special := func(ind int, set bool, exec, noExec byte) {
+		if !set {
+			return
+		}
+		if out[ind] == '-' {
+			out[ind] = noExec
+		} else {
+			out[ind] = exec
+		}
+	}
+	special(3, mode&fs.ModeSetuid != 0, 's', 'S')
+	special(6, mode&fs.ModeSetgid != 0, 's', 'S')
+	special(9, mode&fs.ModeSticky != 0, 't', 'T')
+	return string(out)
+}
+
+//lookupUser and lookupGroup find the names of ids. They're replaced in tests so the output doesn't depend on the system.
+var (
+	lookupUser = func(id string) (string, error) {
+		u, err := user.LookupId(id)
+		if err != nil {
+			return "", err
+		}
+		return u.Username, nil
+	}
+	lookupGroup = func(id string) (string, error) {
+		g, err := user.LookupGroupId(id)
+		if err != nil {
+			return "", err
+		}
+		return g.Name, nil
+	}
+)
+
+//nameCache looks up user and group names, using the id if they don't exist.
+type nameCache struct {
+	users   map[uint32]string
+	groups  map[uint32]string
+	numeric bool
+}
+
+//newNameCache returns an empty nameCache. If numeric, names are never looked up.
+func newNameCache(numeric bool) *nameCache {
+	return &nameCache{
+		users:   make(map[uint32]string),
+		groups:  make(map[uint32]string),
+		numeric: numeric,
+	}
+}
+
+func (n *nameCache) user(id uint32) string {
+	return n.lookup(n.users, lookupUser, id)
+}
+
+func (n *nameCache) group(id uint32) string {
+	return n.lookup(n.groups, lookupGroup, id)
+}
+
+func (n *nameCache) lookup(cache map[uint32]string, find func(string) (string, error), id uint32) string {
+	if name, ok := cache[id]; ok {
+		return name
+	}
+	name := strconv.FormatUint(uint64(id), 10)
+	if !n.numeric {
+		if found, err := find(name); err == nil {
+			name = found
+		}
+	}
+	cache[id] = name
+	return name
+}
+
+//extract extracts the files selected by paths to op.dest, printing the same summary as unsquashfs.
+func extract(out io.Writer, rdr *squashfs.Reader, paths []string, op options) error {
+	if _, err := os.Lstat(op.dest); err == nil && !op.force {
+		return fmt.Errorf("failed to make directory %s, because it already exists. Use -f to overwrite", op.dest)
+	}
+	//Like unsquashfs, every entry that isn't a directory is counted, but a file's blocks are only counted once.
+	files := make(map[uint32]bool)
+	var inodes, blocks int64
+	blockSize := int64(rdr.Info().BlockSize)
+	err := walk(rdr, paths, func(name string, d fs.DirEntry) error {
+		if d.IsDir() {
+			return nil
+		}
+		inodes++
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if num := info.Sys().(*squashfs.InodeInfo).Inode; !files[num] {
+			files[num] = true
+			blocks += (info.Size() + blockSize - 1) / blockSize
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Parallel unsquashfs: Using %d %s\n", op.procs, plural(op.procs, "processor", "processors"))
+	fmt.Fprintf(out, "%d inodes (%d blocks) to write\n\n", inodes, blocks)
+	exOp := squashfs.DefaultOptions()
+	exOp.DevicePolicy = squashfs.DeviceSkip
+	exOp.Workers = op.procs
+	if len(paths) != 1 || paths[0] != "." {
+		exOp.Include = paths
+	}
+	sum, err := rdr.ExtractWithOptions(op.dest, exOp)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out)
+	for _, name := range sum.Skipped {
+		fmt.Fprintln(out, "skipped", filepath.Join(op.dest, filepath.FromSlash(name)))
+	}
+	//unsquashfs counts the destination as a created directory, but the summary doesn't.
+	counts := []struct {
+		n                int
+		single, multiple string
+	}{
+		{sum.Types[0], "file", "files"},
+		{sum.Types[fs.ModeDir] + 1, "directory", "directories"},
+		{sum.Types[fs.ModeSymlink], "symlink", "symlinks"},
+		{sum.Types[fs.ModeDevice] + sum.Types[fs.ModeDevice|fs.ModeCharDevice], "device", "devices"},
+		{sum.Types[fs.ModeNamedPipe], "fifo", "fifos"},
+		{sum.Types[fs.ModeSocket], "socket", "sockets"},
+		{sum.Links, "hardlink", "hardlinks"},
+	}
+	for _, c := range counts {
+		fmt.Fprintf(out, "created %d %s\n", c.n, plural(c.n, c.single, c.multiple))
+	}
+	return nil
+}
+
+//plural returns single if n is 1, otherwise multiple.
+func plural(n int, single, multiple string) string {
+	if n == 1 {
+		return single
+	}
+	return multiple
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+//testArchive is an archive made by mksquashfs. See testdata/mksquashfs.sh.
+var testArchive = filepath.Join("..", "..", "testdata", "mksquashfs.sqfs")
+
+//fixedNames replaces the user and group lookups, and the local time zone, so the output doesn't depend on the system.
+//uid 1000 doesn't have a name, so it's printed as a number.
+func fixedNames(t *testing.T) {
+	users := map[string]string{"0": "root"}
+	groups := map[string]string{"0": "root", "100": "users"}
+	find := func(names map[string]string) func(string) (string, error) {
+		return func(id string) (string, error) {
+			if name, ok := names[id]; ok {
+				return name, nil
+			}
+			return "", errors.New("unknown id " + id)
+		}
+	}
+	oldUser, oldGroup, oldLocal := lookupUser, lookupGroup, time.Local
+	lookupUser, lookupGroup, time.Local = find(users), find(groups), time.UTC
+	t.Cleanup(func() {
+		lookupUser, lookupGroup, time.Local = oldUser, oldGroup, oldLocal
+	})
+}
+
+//The golden files match the output of squashfs-tools' unsquashfs, with the names from fixedNames.
+//It can't run -s on this archive, so stat.golden was only checked against it's output for other archives.
+//Run the tests with -update to rewrite them.
+func TestGolden(t *testing.T) {
+	fixedNames(t)
+	dest := t.TempDir()
+	tests := []struct {
+		golden string
+		args   []string
+	}{
+		{"list", []string{"-l", testArchive}},
+		{"longlist", []string{"-ll", testArchive, "small", "sparse", "symlink", "hardlink", "fifo", "null", "big/file1", "big/file99*"}},
+		{"numeric", []string{"-lln", testArchive, "null", "small"}},
+		{"stat", []string{"-s", testArchive}},
+		{"extract", []string{"-no-progress", "-p", "2", "-d", filepath.Join(dest, "extract"), testArchive, "small", "sparse", "big/file1", "symlink", "hardlink", "fifo"}},
+		{"processor", []string{"-no-progress", "-p", "1", "-d", filepath.Join(dest, "processor"), testArchive, "big/file1*", "small"}},
+	}
+	for _, test := range tests {
+		var stdout, stderr bytes.Buffer
+		code := run(test.args, &stdout, &stderr)
+		if code != 0 {
+			t.Errorf("%s: exit code %d: %s", test.golden, code, stderr.String())
+			continue
+		}
+		golden := filepath.Join("testdata", test.golden+".golden")
+		if *update {
+			err := os.WriteFile(golden, stdout.Bytes(), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(stdout.Bytes(), want) {
+			t.Errorf("%s: output differs from %s:\n%s", test.golden, golden, stdout.String())
+		}
+	}
+	//The files selected with wildcards are extracted, and hard links are kept.
+	for i := 10; i < 20; i++ {
+		dat, err := os.ReadFile(filepath.Join(dest, "processor", "big", "file"+strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(dat) != strconv.Itoa(i) {
+			t.Errorf("big/file%d: got %q", i, dat)
+		}
+	}
+	small, err := os.Stat(filepath.Join(dest, "extract", "small"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hard, err := os.Stat(filepath.Join(dest, "extract", "hardlink"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(small, hard) {
+		t.Error("hardlink isn't linked to small")
+	}
+}
+
+func TestExistingDest(t *testing.T) {
+	dest := t.TempDir()
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"-d", dest, testArchive, "small"}, &stdout, &stderr); code != 1 {
+		t.Errorf("exit code %d, want 1", code)
+	}
+	if code := run([]string{"-f", "-d", dest, testArchive, "small"}, &stdout, &stderr); code != 0 {
+		t.Errorf("exit code %d with -f: %s", code, stderr.String())
+	}
+	dat, err := os.ReadFile(filepath.Join(dest, "small"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(dat) != "hello mksquashfs" {
+		t.Errorf("small: got %q", dat)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/CalebQ42/squashfs"
+)
+
+var compressionNames = map[uint16]string{
+	squashfs.GZipCompression: "gzip",
+	squashfs.LZMACompression: "lzma",
+	squashfs.LZOCompression:  "lzo",
+	squashfs.XZCompression:   "xz",
+	squashfs.LZ4Compression:  "lz4",
+	squashfs.ZSTDCompression: "zstd",
+}
+
+//unless returns word, unless b is true.
+func unless(b bool, word string) string {
+	if b {
+		return ""
+	}
+	return word
+}
+
+//xattrCount reads the number of xattr ids from the header of the archive's xattr table.
+func xattrCount(r io.ReaderAt, info squashfs.Info) (uint32, error) {
+	if info.XattrTableStart == math.MaxUint64 {
+		return 0, nil
+	}
+	//The header is the start of the key/value pairs, followed by the count.
+	var header [16]byte
+	_, err := r.ReadAt(header[:], int64(info.XattrTableStart))
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(header[8:]), nil
+}
+
+//printStat prints the archive's information the same as unsquashfs -s.
+func printStat(out io.Writer, archive string, info squashfs.Info, xattrs uint32) {
+	fmt.Fprintf(out, "Found a valid SQUASHFS 4:0 superblock on %s.\n", archive)
+	fmt.Fprintf(out, "Creation or last append time %s\n", info.ModTime.Format("Mon Jan _2 15:04:05 2006"))
+	fmt.Fprintf(out, "Filesystem size %d bytes (%.2f Kbytes / %.2f Mbytes)\n", info.Size, float64(info.Size)/1024, float64(info.Size)/(1024*1024))
+	name, ok := compressionNames[info.Compression]
+	if !ok {
+		name = fmt.Sprintf("unknown (%d)", info.Compression)
+	}
+	fmt.Fprintf(out, "Compression %s\n", name)
+	printCompressionOptions(out, info.CompressionOptions)
+	fmt.Fprintf(out, "Block size %d\n", info.BlockSize)
+	fmt.Fprintf(out, "Filesystem is %sexportable via NFS\n", unless(info.Exportable, "not "))
+	fmt.Fprintf(out, "Inodes are %scompressed\n", unless(!info.UncompressedInodes, "un"))
+	fmt.Fprintf(out, "Data is %scompressed\n", unless(!info.UncompressedData, "un"))
+	fmt.Fprintf(out, "Uids/Gids (Id table) are %scompressed\n", unless(!info.UncompressedInodes && !info.UncompressedIDs, "un"))
+	if info.NoFragments {
+		fmt.Fprintln(out, "Fragments are not stored")
+	} else {
+		fmt.Fprintf(out, "Fragments are %scompressed\n", unless(!info.UncompressedFragments, "un"))
+		fmt.Fprintf(out, "Tailends are %spacked into fragments\n", unless(info.AlwaysFragment, "not "))
+	}
+	if info.NoXattrs {
+		fmt.Fprintln(out, "Xattrs are not stored")
+	} else {
+		fmt.Fprintf(out, "Xattrs are %scompressed\n", unless(!info.UncompressedXattrs, "un"))
+	}
+	fmt.Fprintf(out, "Duplicates are %sremoved\n", unless(info.Duplicates, "not "))
+	fmt.Fprintf(out, "Number of fragments %d\n", info.FragCount)
+	fmt.Fprintf(out, "Number of inodes %d\n", info.InodeCount)
+	fmt.Fprintf(out, "Number of ids %d\n", info.IdCount)
+	if !info.NoXattrs {
+		fmt.Fprintf(out, "Number of xattr ids %d\n", xattrs)
+	}
+}
+
+func printCompressionOptions(out io.Writer, op squashfs.CompressionOptions) {
+	switch op := op.(type) {
+	case squashfs.GZipOptions:
+		fmt.Fprintf(out, "\tcompression-level %d\n", op.Level)
+		fmt.Fprintf(out, "\twindow-size %d\n", op.WindowSize)
+		strategies := selected(uint32(op.Strategies), []string{"default", "filtered", "huffman_only", "run_length_encoded", "fixed"})
+		if strategies == "" {
+			strategies = "default"
+		}
+		fmt.Fprintf(out, "\tStrategies selected: %s\n", strategies)
+	case squashfs.XzOptions:
+		fmt.Fprintf(out, "\tDictionary size %d\n", op.DictionarySize)
+		if filters := selected(op.Filters, []string{"x86", "powerpc", "ia64", "arm", "armthumb", "sparc"}); filters != "" {
+			fmt.Fprintf(out, "\tFilters selected: %s\n", filters)
+		} else {
+			fmt.Fprintln(out, "\tNo filters specified")
+		}
+	case squashfs.Lz4Options:
+		if op.Flags&squashfs.Lz4HighCompression != 0 {
+			fmt.Fprintln(out, "\tHigh Compression option specified (-Xhc)")
+		}
+	case squashfs.ZstdOptions:
+		fmt.Fprintf(out, "\tcompression-level %d\n", op.Level)
+	case squashfs.LzoOptions:
+		algorithms := []string{"lzo1x_1", "lzo1x_1_11", "lzo1x_1_12", "lzo1x_1_15", "lzo1x_999"}
+		if int(op.Algorithm) < len(algorithms) {
+			fmt.Fprintf(out, "\talgorithm %s\n", algorithms[op.Algorithm])
+		}
+		if op.Algorithm == squashfs.Lzo1x999 {
+			fmt.Fprintf(out, "\tcompression level %d\n", op.Level)
+		}
+	}
+}
+
+//selected returns the names of the bits set in flags, separated by commas.
+func selected(flags uint32, names []string) string {
+	var out []string
+	for i, name := range names {
+		if flags&(1<<i) != 0 {
+			out = append(out, name)
+		}
+	}
+	return strings.Join(out, ", ")
+}
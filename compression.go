@@ -0,0 +1,297 @@
+package squashfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/bits"
+	"sync"
+
+	"github.com/CalebQ42/squashfs/internal/compress"
+	"github.com/CalebQ42/squashfs/internal/decompress"
+	"github.com/CalebQ42/squashfs/internal/toreader"
+)
+
+//CompressionOptions are the compressor specific options that can be stored after the superblock.
+//Is one of GZipOptions, XzOptions, Lz4Options, ZstdOptions, or LzoOptions.
+type CompressionOptions interface {
+	//Compression returns the compression type the options are for.
+	Compression() uint16
+	validate() error
+}
+
+var (
+	ErrCompressionOptions = errors.New("unsupported compression options")
+)
+
+//Decompressor decompresses data and metadata blocks.
+//A custom Decompressor can be used for an archive with RegisterDecompressor or ReaderOptions.Decompressor.
+//If Resetable returns false, Reset is never called.
+type Decompressor = decompress.Decompressor
+
+var (
+	decompressors    = make(map[uint16]Decompressor)
+	decompressorsMut sync.RWMutex
+)
+
+//RegisterDecompressor sets the Decompressor used for archives with the given compression type, replacing the built in one if present.
+//comp doesn't have to be one of the *Compression constants. Only effects Readers created afterwards.
+//If d is nil, the built in Decompressor is used again.
+func RegisterDecompressor(comp uint16, d Decompressor) {
+	decompressorsMut.Lock()
+	defer decompressorsMut.Unlock()
+	if d == nil {
+		delete(decompressors, comp)
+		return
+	}
+	decompressors[comp] = d
+}
+
+//GZip strategies
+const (
+	GZipDefault = uint16(1 << iota)
+	GZipFiltered
+	GZipHuffmanOnly
+	GZipRunLengthEncoded
+	GZipFixed
+)
+
+//GZipOptions are the options for gzip compression.
+type GZipOptions struct {
+	Level      uint32
+	WindowSize uint16
+	Strategies uint16
+}
+
+func (GZipOptions) Compression() uint16 { return GZipCompression }
+
+func (g GZipOptions) validate() error {
+	if g.Level < 1 || g.Level > 9 || g.WindowSize < 8 || g.WindowSize > 15 || g.Strategies&^0x1F != 0 {
+		return ErrCompressionOptions
+	}
+	return nil
+}
+
+//Xz filters
+const (
+	XzX86 = uint32(1 << iota)
+	XzPowerPC
+	XzIA64
+	XzArm
+	XzArmThumb
+	XzSparc
+)
+
+//XzOptions are the options for xz compression.
+type XzOptions struct {
+	DictionarySize uint32
+	Filters        uint32
+}
+
+func (XzOptions) Compression() uint16 { return XZCompression }
+
+func (x XzOptions) validate() error {
+	//The dictionary size must be either 2^n or 2^n+2^(n-1)
+	dict := x.DictionarySize >> bits.TrailingZeros32(x.DictionarySize)
+	if x.DictionarySize < 8192 || (dict != 1 && dict != 3) || x.Filters&^0x3F != 0 {
+		return ErrCompressionOptions
+	}
+	return nil
+}
+
+//Lz4 flags
+const (
+	Lz4HighCompression = uint32(1)
+)
+
+//Lz4Options are the options for lz4 compression.
+type Lz4Options struct {
+	//Version is the lz4 format version. Only 1 (the legacy format) is supported.
+	Version uint32
+	Flags   uint32
+}
+
+func (Lz4Options) Compression() uint16 { return LZ4Compression }
+
+func (l Lz4Options) validate() error {
+	if l.Version != 1 || l.Flags&^Lz4HighCompression != 0 {
+		return ErrCompressionOptions
+	}
+	return nil
+}
+
+//ZstdOptions are the options for zstd compression.
+type ZstdOptions struct {
+	Level uint32
+}
+
+func (ZstdOptions) Compression() uint16 { return ZSTDCompression }
+
+func (z ZstdOptions) validate() error {
+	if z.Level < 1 || z.Level > 22 {
+		return ErrCompressionOptions
+	}
+	return nil
+}
+
+//Lzo algorithms
+const (
+	Lzo1x1 = uint32(iota)
+	Lzo1x1_11
+	Lzo1x1_12
+	Lzo1x1_15
+	Lzo1x999
+)
+
+//LzoOptions are the options for lzo compression.
+type LzoOptions struct {
+	Algorithm uint32
+	//Level is only used with Lzo1x999 and must be 0 otherwise.
+	Level uint32
+}
+
+func (LzoOptions) Compression() uint16 { return LZOCompression }
+
+func (l LzoOptions) validate() error {
+	if l.Algorithm > Lzo1x999 {
+		return ErrCompressionOptions
+	}
+	if (l.Algorithm == Lzo1x999 && (l.Level < 1 || l.Level > 9)) || (l.Algorithm != Lzo1x999 && l.Level != 0) {
+		return ErrCompressionOptions
+	}
+	return nil
+}
+
+//readCompressionOptions reads the options stored directly after the superblock.
+func readCompressionOptions(r io.ReaderAt, comp uint16) (op CompressionOptions, err error) {
+	var raw uint16
+	err = binary.Read(toreader.NewReader(r, 96), binary.LittleEndian, &raw)
+	if err != nil {
+		return
+	}
+	//The options are always stored as an uncompressed metadata block.
+	if raw&0x8000 != 0x8000 {
+		return nil, ErrCompressionOptions
+	}
+	rdr := io.LimitReader(toreader.NewReader(r, 98), int64(raw&^0x8000))
+	switch comp {
+	case GZipCompression:
+		var g GZipOptions
+		err = binary.Read(rdr, binary.LittleEndian, &g)
+		op = g
+	case XZCompression:
+		var x XzOptions
+		err = binary.Read(rdr, binary.LittleEndian, &x)
+		op = x
+	case LZ4Compression:
+		var l Lz4Options
+		err = binary.Read(rdr, binary.LittleEndian, &l)
+		op = l
+	case ZSTDCompression:
+		var z ZstdOptions
+		err = binary.Read(rdr, binary.LittleEndian, &z)
+		op = z
+	case LZOCompression:
+		var l LzoOptions
+		err = binary.Read(rdr, binary.LittleEndian, &l)
+		op = l
+	default:
+		//Options for unknown compression types can't be decoded, but a custom Decompressor might not need them.
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return op, op.validate()
+}
+
+//newDecompressor returns the decompressor for the compression type, configured with the options if present.
+//Decompressors set with RegisterDecompressor take priority.
+func newDecompressor(comp uint16, op CompressionOptions) (decompress.Decompressor, error) {
+	decompressorsMut.RLock()
+	d, ok := decompressors[comp]
+	decompressorsMut.RUnlock()
+	if ok {
+		return d, nil
+	}
+	switch comp {
+	case GZipCompression:
+		return decompress.GZip{}, nil
+	case LZMACompression:
+		return decompress.Lzma{}, nil
+	case LZOCompression:
+		return decompress.Lzo{}, nil
+	case XZCompression:
+		if x, ok := op.(XzOptions); ok {
+			return decompress.Xz{DictSize: x.DictionarySize}, nil
+		}
+		return decompress.Xz{}, nil
+	case LZ4Compression:
+		return decompress.Lz4{}, nil
+	case ZSTDCompression:
+		return &decompress.Zstd{}, nil
+	}
+	return nil, ErrCompression
+}
+
+//newCompressor returns the compressor for the compression type, configured with the options if present.
+func newCompressor(comp uint16, op CompressionOptions) (compress.Compressor, error) {
+	if op != nil {
+		if op.Compression() != comp {
+			return nil, ErrCompressionOptions
+		}
+		err := op.validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+	switch comp {
+	case GZipCompression:
+		c := compress.GZip{}
+		if g, ok := op.(GZipOptions); ok {
+			c.Level = int(g.Level)
+			if g.WindowSize != 15 || (g.Strategies != 0 && g.Strategies != GZipDefault) {
+				//Only the defaults are supported when writing.
+				return nil, ErrCompressionOptions
+			}
+		}
+		return c, nil
+	case LZMACompression:
+		if op != nil {
+			return nil, ErrCompressionOptions
+		}
+		return compress.Lzma{}, nil
+	case LZOCompression:
+		c := compress.Lzo{}
+		if l, ok := op.(LzoOptions); ok {
+			if l.Algorithm != Lzo1x1 && l.Algorithm != Lzo1x999 {
+				return nil, ErrCompressionOptions
+			}
+			c.Fast = l.Algorithm == Lzo1x1
+			c.Level = int(l.Level)
+		}
+		return c, nil
+	case XZCompression:
+		c := compress.Xz{}
+		if x, ok := op.(XzOptions); ok {
+			if x.Filters != 0 {
+				return nil, ErrCompressionOptions
+			}
+			c.DictSize = int(x.DictionarySize)
+		}
+		return c, nil
+	case LZ4Compression:
+		c := compress.Lz4{}
+		if l, ok := op.(Lz4Options); ok {
+			c.HC = l.Flags&Lz4HighCompression == Lz4HighCompression
+		}
+		return c, nil
+	case ZSTDCompression:
+		c := &compress.Zstd{}
+		if z, ok := op.(ZstdOptions); ok {
+			c.Level = int(z.Level)
+		}
+		return c, nil
+	}
+	return nil, ErrCompression
+}
@@ -45,7 +45,7 @@ func TestSquashfs(t *testing.T) {
 	os.RemoveAll(wd + "/testing/" + squashfsName + ".d")
 	op := DefaultOptions()
 	op.Verbose = true
-	err = rdr.ExtractWithOptions(wd+"/testing/"+squashfsName+".d", op)
+	_, err = rdr.ExtractWithOptions(wd+"/testing/"+squashfsName+".d", op)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -65,7 +65,7 @@ func TestSquashfsFromReader(t *testing.T) {
 	os.RemoveAll("testing/" + squashfsName + ".d")
 	op := DefaultOptions()
 	op.Verbose = true
-	err = rdr.ExtractWithOptions("testing/"+squashfsName+".d", op)
+	_, err = rdr.ExtractWithOptions("testing/"+squashfsName+".d", op)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -101,7 +101,7 @@ func TestAppImage(t *testing.T) {
 	os.RemoveAll(wd + "/testing/firefox")
 	op := DefaultOptions()
 	op.Verbose = true
-	err = rdr.ExtractWithOptions(wd+"/testing/firefox", op)
+	_, err = rdr.ExtractWithOptions(wd+"/testing/firefox", op)
 	t.Fatal(err)
 }
 
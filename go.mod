@@ -9,6 +9,7 @@ require (
 	github.com/rasky/go-lzo v0.0.0-20200203143853-96a758eda86e
 	github.com/therootcompany/xz v1.0.1
 	github.com/ulikunitz/xz v0.5.10
+	golang.org/x/sys v0.25.0
 )
 
 require (
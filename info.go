@@ -0,0 +1,92 @@
+package squashfs
+
+import (
+	"io"
+	"time"
+)
+
+//Info is the information about an archive that's stored in it's superblock.
+type Info struct {
+	//ModTime is when the archive was created.
+	ModTime time.Time
+	//CompressionOptions are the options stored after the superblock. nil if there are none.
+	CompressionOptions CompressionOptions
+	//Size is the number of bytes used by the archive. Archives are usually padded past this.
+	Size         uint64
+	RootInodeRef uint64
+	//Locations of the tables in the archive. If a table isn't present, it's location is 0xFFFFFFFFFFFFFFFF.
+	InodeTableStart  uint64
+	DirTableStart    uint64
+	FragTableStart   uint64
+	ExportTableStart uint64
+	IdTableStart     uint64
+	XattrTableStart  uint64
+	BlockSize        uint32
+	InodeCount       uint32
+	FragCount        uint32
+	//Compression is one of the *Compression constants.
+	Compression uint16
+	IdCount     uint16
+	//Flags are the raw superblock flags. They're also decoded into the below fields.
+	Flags                 uint16
+	UncompressedInodes    bool
+	UncompressedData      bool
+	UncompressedFragments bool
+	NoFragments           bool
+	AlwaysFragment        bool
+	Duplicates            bool
+	Exportable            bool
+	UncompressedXattrs    bool
+	NoXattrs              bool
+	HasCompressionOptions bool
+	UncompressedIDs       bool
+}
+
+func newInfo(s superblock, op CompressionOptions) Info {
+	return Info{
+		ModTime:               time.Unix(int64(s.ModTime), 0),
+		CompressionOptions:    op,
+		Size:                  s.Size,
+		RootInodeRef:          s.RootInodeRef,
+		InodeTableStart:       s.InodeTableStart,
+		DirTableStart:         s.DirTableStart,
+		FragTableStart:        s.FragTableStart,
+		ExportTableStart:      s.ExportTableStart,
+		IdTableStart:          s.IdTableStart,
+		XattrTableStart:       s.XattrTableStart,
+		BlockSize:             s.BlockSize,
+		InodeCount:            s.InodeCount,
+		FragCount:             s.FragCount,
+		Compression:           s.CompType,
+		IdCount:               s.IdCount,
+		Flags:                 s.Flags,
+		UncompressedInodes:    s.uncompressedInodes(),
+		UncompressedData:      s.uncompressedData(),
+		UncompressedFragments: s.uncompressedFragments(),
+		NoFragments:           s.noFragments(),
+		AlwaysFragment:        s.alwaysFragment(),
+		Duplicates:            s.duplicates(),
+		Exportable:            s.exportable(),
+		UncompressedXattrs:    s.uncompressedXattrs(),
+		NoXattrs:              s.noXattrs(),
+		HasCompressionOptions: s.compressionOptions(),
+		UncompressedIDs:       s.uncompressedIDs(),
+	}
+}
+
+//Probe validates the superblock of the archive in r and returns it's Info.
+//Only the superblock and compression options are read, so it's much cheaper then NewReader.
+func Probe(r io.ReaderAt) (Info, error) {
+	s, err := readSuperblock(r)
+	if err != nil {
+		return Info{}, err
+	}
+	var op CompressionOptions
+	if s.compressionOptions() {
+		op, err = readCompressionOptions(r, s.CompType)
+		if err != nil {
+			return Info{}, err
+		}
+	}
+	return newInfo(s, op), nil
+}
@@ -0,0 +1,30 @@
+package compress
+
+import (
+	"bytes"
+
+	"github.com/klauspost/compress/zlib"
+)
+
+type GZip struct {
+	//Level is the compression level. If 0, zlib.BestCompression is used.
+	Level int
+}
+
+func (g GZip) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	level := g.Level
+	if level == 0 {
+		level = zlib.BestCompression
+	}
+	w, err := zlib.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	return buf.Bytes(), err
+}
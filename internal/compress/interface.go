@@ -0,0 +1,7 @@
+package compress
+
+type Compressor interface {
+	//Compress compresses a single block of data.
+	//If the result isn't smaller then the input, the caller stores the data uncompressed.
+	Compress(data []byte) ([]byte, error)
+}
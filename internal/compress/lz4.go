@@ -0,0 +1,28 @@
+package compress
+
+import "github.com/pierrec/lz4/v4"
+
+type Lz4 struct {
+	//HC uses high compression mode.
+	HC bool
+}
+
+func (l Lz4) Compress(data []byte) ([]byte, error) {
+	out := make([]byte, lz4.CompressBlockBound(len(data)))
+	var n int
+	var err error
+	if l.HC {
+		var c lz4.CompressorHC
+		n, err = c.CompressBlock(data, out)
+	} else {
+		var c lz4.Compressor
+		n, err = c.CompressBlock(data, out)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return data, nil
+	}
+	return out[:n], nil
+}
@@ -0,0 +1,36 @@
+package compress
+
+import (
+	"bytes"
+
+	"github.com/ulikunitz/xz/lzma"
+)
+
+type Lzma struct{}
+
+func (l Lzma) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := lzma.WriterConfig{
+		DictCap:      dictCap(len(data)),
+		SizeInHeader: true,
+		Size:         int64(len(data)),
+	}.NewWriter(&buf)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	return buf.Bytes(), err
+}
+
+//dictCap keeps the dictionary no larger then the data being compressed.
+//Otherwise decompression needs to allocate the default 8MiB dictionary for every block.
+func dictCap(size int) int {
+	if size < lzma.MinDictCap {
+		return lzma.MinDictCap
+	}
+	return size
+}
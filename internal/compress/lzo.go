@@ -0,0 +1,21 @@
+package compress
+
+import "github.com/rasky/go-lzo"
+
+type Lzo struct {
+	//Fast uses lzo1x_1 instead of lzo1x_999.
+	Fast bool
+	//Level is the lzo1x_999 compression level. If 0, 8 is used.
+	Level int
+}
+
+func (l Lzo) Compress(data []byte) ([]byte, error) {
+	if l.Fast {
+		return lzo.Compress1X(data), nil
+	}
+	level := l.Level
+	if level == 0 {
+		level = 8
+	}
+	return lzo.Compress1X999Level(data, level), nil
+}
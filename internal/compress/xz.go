@@ -0,0 +1,33 @@
+package compress
+
+import (
+	"bytes"
+
+	"github.com/ulikunitz/xz"
+)
+
+type Xz struct {
+	//DictSize is the maximum dictionary size. If 0, there's no limit.
+	DictSize int
+}
+
+func (x Xz) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	dict := dictCap(len(data))
+	if x.DictSize != 0 && x.DictSize < dict {
+		dict = x.DictSize
+	}
+	w, err := xz.WriterConfig{
+		DictCap:  dict,
+		CheckSum: xz.CRC32,
+	}.NewWriter(&buf)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	return buf.Bytes(), err
+}
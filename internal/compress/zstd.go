@@ -0,0 +1,23 @@
+package compress
+
+import "github.com/klauspost/compress/zstd"
+
+type Zstd struct {
+	enc *zstd.Encoder
+	//Level is the zstd compression level. If 0, the default is used.
+	Level int
+}
+
+func (z *Zstd) Compress(data []byte) (out []byte, err error) {
+	if z.enc == nil {
+		level := zstd.SpeedDefault
+		if z.Level != 0 {
+			level = zstd.EncoderLevelFromZstd(z.Level)
+		}
+		z.enc, err = zstd.NewWriter(nil, zstd.WithEncoderLevel(level))
+		if err != nil {
+			return
+		}
+	}
+	return z.enc.EncodeAll(data, nil), nil
+}
@@ -1,7 +1,11 @@
 package data
 
 import (
+	"context"
+	"errors"
 	"io"
+	"runtime"
+	"sync"
 
 	"github.com/CalebQ42/squashfs/internal/decompress"
 	"github.com/CalebQ42/squashfs/internal/toreader"
@@ -12,16 +16,23 @@ type FullReader struct {
 	d         decompress.Decompressor
 	fragRdr   func() (io.Reader, error)
 	sizes     []uint32
+	offsets   []uint64
+	size      int64
 	blockSize uint32
-	start     uint64
 }
 
-func NewFullReader(r io.ReaderAt, start uint64, d decompress.Decompressor, blockSizes []uint32, blockSize uint32) *FullReader {
+func NewFullReader(r io.ReaderAt, start uint64, d decompress.Decompressor, blockSizes []uint32, blockSize uint32, size uint64) *FullReader {
+	offsets := make([]uint64, len(blockSizes))
+	for i := range blockSizes {
+		offsets[i] = start
+		start += uint64(realSize(blockSizes[i]))
+	}
 	return &FullReader{
 		r:         r,
-		start:     start,
 		blockSize: blockSize,
 		sizes:     blockSizes,
+		offsets:   offsets,
+		size:      int64(size),
 		d:         d,
 	}
 }
@@ -34,112 +45,268 @@ func (r *FullReader) AddFragment(rdr func() (io.Reader, error)) {
 type outDat struct {
 	err  error
 	data []byte
+	hole int64
 	i    int
 }
 
-func (r FullReader) process(index int, offset int64, out chan outDat) {
-	var err error
-	var dat []byte
-	var rdr io.ReadCloser
+//holeSize returns the size of the hole at index. Holes are a full block, unless they're at the end of the file.
+func (r FullReader) holeSize(index int) int64 {
+	size := r.size - int64(index)*int64(r.blockSize)
+	if size > int64(r.blockSize) {
+		size = int64(r.blockSize)
+	}
+	return size
+}
+
+//isHole returns if the block at index is a hole.
+func (r FullReader) isHole(index int) bool {
+	return realSize(r.sizes[index]) == 0 && (index != len(r.sizes)-1 || r.fragRdr == nil)
+}
+
+//readBlock returns the uncompressed data of the block at index, including the fragment.
+func (r FullReader) readBlock(index int) (dat []byte, err error) {
+	return r.readBlockContext(context.Background(), index)
+}
+
+//readBlockContext is readBlock, but stops decompressing once ctx is done.
+func (r FullReader) readBlockContext(ctx context.Context, index int) (dat []byte, err error) {
+	if r.isHole(index) {
+		return make([]byte, r.holeSize(index)), nil
+	}
+	if index == len(r.sizes)-1 && r.fragRdr != nil {
+		var rdr io.Reader
+		rdr, err = r.fragRdr()
+		if err != nil {
+			return
+		}
+		dat, err = io.ReadAll(rdr)
+		if clr, ok := rdr.(io.Closer); ok {
+			clr.Close()
+		}
+		return
+	}
 	size := realSize(r.sizes[index])
-	if size == 0 {
+	if size > r.blockSize {
+		return nil, ErrBlockSize
+	}
+	offset := int64(r.offsets[index])
+	if size != r.sizes[index] {
+		dat = make([]byte, size)
+		_, err = r.r.ReadAt(dat, offset)
+		return
+	}
+	//Special workaround for zstd for increased performancce.
+	if zstd, ok := r.d.(*decompress.Zstd); ok {
+		dat = make([]byte, size)
+		_, err = r.r.ReadAt(dat, offset)
+		if err == nil {
+			dat, err = zstd.Decode(dat)
+		}
+	} else {
+		var rdr io.ReadCloser
+		rdr, err = r.d.Reader(ctxReader{ctx: ctx, r: io.LimitReader(toreader.NewReader(r.r, offset), int64(size))})
+		if err != nil {
+			return
+		}
+		//Read one byte past the limit to catch blocks that are too large without reading all of them.
+		dat, err = io.ReadAll(io.LimitReader(rdr, int64(r.blockSize)+1))
+		rdr.Close()
+	}
+	if err == nil && len(dat) > int(r.blockSize) {
+		err = ErrBlockSize
+	}
+	return
+}
+
+func (r FullReader) process(ctx context.Context, index int, out chan outDat) {
+	if err := ctx.Err(); err != nil {
 		out <- outDat{
-			i:    index,
-			err:  nil,
-			data: make([]byte, r.blockSize),
+			i:   index,
+			err: err,
 		}
 		return
 	}
-	// rdr := io.LimitReader(toreader.NewReader(r.r, offset), int64(size))
-	if size == r.sizes[index] {
-		//Special workaround for zstd for increased performancce.
-		if zstd, ok := r.d.(*decompress.Zstd); ok {
-			dat = make([]byte, size)
-			_, err = r.r.ReadAt(dat, offset)
-			if err == nil {
-				dat, err = zstd.Decode(dat)
-			}
-		} else {
-			rdr, err = r.d.Reader(io.LimitReader(toreader.NewReader(r.r, offset), int64(size)))
-			if err == nil {
-				dat, err = io.ReadAll(rdr)
-			}
+	if r.isHole(index) {
+		out <- outDat{
+			i:    index,
+			hole: r.holeSize(index),
 		}
-	} else {
-		dat = make([]byte, size)
-		_, err = r.r.ReadAt(dat, offset)
+		return
 	}
+	dat, err := r.readBlockContext(ctx, index)
 	out <- outDat{
 		i:    index,
 		err:  err,
 		data: dat,
 	}
-	if clr, ok := rdr.(io.Closer); ok {
-		clr.Close()
-	}
 }
 
-func (r FullReader) WriteTo(w io.Writer) (n int64, err error) {
-	out := make(chan outDat, len(r.sizes))
-	offset := r.start
+//writeBlocks decompresses the blocks and calls write with them in order.
+//A block is only decompressed in a new goroutine if a token can be sent to workers, and it's received once the block is done.
+//If none can, the next block is decompressed by the caller. No more then cap(workers) blocks are kept waiting to be written.
+//If workers is nil, a new one is used with a token for each CPU.
+//If ctx is done, or there's an error, the remaining blocks aren't decompressed. All goroutines are finished before returning.
+func (r FullReader) writeBlocks(ctx context.Context, workers chan struct{}, write func(outDat) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+	if workers == nil {
+		workers = make(chan struct{}, runtime.NumCPU())
+	}
 	num := len(r.sizes)
-	for i := 0; i < num; i++ {
-		if i == num-1 && r.fragRdr != nil {
-			go func() {
-				rdr, e := r.fragRdr()
-				if err != nil {
-					out <- outDat{
-						i:   num - 1,
-						err: e,
-					}
-					return
-				}
-				dat, e := io.ReadAll(rdr)
-				out <- outDat{
-					i:    num - 1,
-					err:  e,
-					data: dat,
-				}
-				if clr, ok := rdr.(io.Closer); ok {
-					clr.Close()
-				}
-			}()
-			continue
+	out := make(chan outDat, num)
+	window := cap(workers)
+	cache := make(map[int]outDat)
+	next := 0
+	for cur := 0; cur < num; {
+		for next < num && next-cur < window && acquire(workers) {
+			wg.Add(1)
+			go func(i int) {
+				r.process(ctx, i, out)
+				release(workers)
+				wg.Done()
+			}(next)
+			next++
 		}
-		go r.process(i, int64(offset), out)
-		offset += uint64(realSize(r.sizes[i]))
+		if next == cur {
+			r.process(ctx, cur, out)
+			next++
+		}
+		dat, ok := cache[cur]
+		if !ok {
+			select {
+			case dat = <-out:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			if dat.err != nil {
+				return dat.err
+			}
+			if dat.i != cur {
+				cache[dat.i] = dat
+				continue
+			}
+		}
+		delete(cache, cur)
+		err := write(dat)
+		if err != nil {
+			return err
+		}
+		cur++
 	}
-	cache := make(map[int]outDat)
+	return nil
+}
+
+//acquire sends a token to workers if it can without waiting.
+func acquire(workers chan struct{}) bool {
+	select {
+	case workers <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+//release receives the token sent by acquire.
+func release(workers chan struct{}) {
+	<-workers
+}
+
+func (r FullReader) WriteTo(w io.Writer) (n int64, err error) {
+	return r.WriteToContext(context.Background(), w)
+}
+
+//WriteToContext is WriteTo, but stops once ctx is done and returns ctx.Err().
+func (r FullReader) WriteToContext(ctx context.Context, w io.Writer) (n int64, err error) {
+	var zero []byte
 	var tmpN int
-	for cur := 0; cur < num; {
-		dat := <-out
-		if dat.err != nil {
-			err = dat.err
-			return
+	err = r.writeBlocks(ctx, nil, func(dat outDat) error {
+		if dat.hole > 0 {
+			if zero == nil {
+				zero = make([]byte, r.blockSize)
+			}
+			dat.data = zero[:dat.hole]
 		}
-		if dat.i != cur {
-			cache[dat.i] = dat
-			continue
+		tmpN, err = w.Write(dat.data)
+		n += int64(tmpN)
+		return err
+	})
+	return
+}
+
+//SparseWriter is an io.Writer that can skip over holes, such as an *os.File.
+type SparseWriter interface {
+	io.Writer
+	io.Seeker
+	Truncate(size int64) error
+}
+
+//WriteToSparse is like WriteTo, but seeks over holes instead of writing zeros, then truncates w to the file's size.
+//w should be empty and at it's start.
+func (r FullReader) WriteToSparse(w SparseWriter) (n int64, err error) {
+	return r.WriteToSparseContext(context.Background(), w)
+}
+
+//WriteToSparseContext is WriteToSparse, but stops once ctx is done and returns ctx.Err().
+func (r FullReader) WriteToSparseContext(ctx context.Context, w SparseWriter) (n int64, err error) {
+	return r.WriteToSparseWorkers(ctx, w, nil)
+}
+
+//WriteToSparseWorkers is WriteToSparseContext, but limits how many blocks are decompressed at once with workers.
+//workers can be shared, so one limit covers everything using it. See writeBlocks.
+//Without workers, such as with WriteTo and WriteToSparse, a block is decompressed at once for each CPU.
+func (r FullReader) WriteToSparseWorkers(ctx context.Context, w SparseWriter, workers chan struct{}) (n int64, err error) {
+	var tmpN int
+	err = r.writeBlocks(ctx, workers, func(dat outDat) error {
+		if dat.hole > 0 {
+			n += dat.hole
+			_, err = w.Seek(dat.hole, io.SeekCurrent)
+			return err
 		}
 		tmpN, err = w.Write(dat.data)
 		n += int64(tmpN)
+		return err
+	})
+	if err != nil {
+		return
+	}
+	//Holes at the end of the file don't change it's size until it's truncated.
+	return n, w.Truncate(n)
+}
+
+//ReadAt reads len(p) bytes starting at off. Only the blocks that contain the range are decompressed.
+func (r FullReader) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.size-off {
+		p = p[:r.size-off]
+		defer func() {
+			if err == nil {
+				err = io.EOF
+			}
+		}()
+	}
+	var dat []byte
+	for n < len(p) {
+		pos := off + int64(n)
+		index := int(pos / int64(r.blockSize))
+		if index >= len(r.sizes) {
+			return n, io.ErrUnexpectedEOF
+		}
+		dat, err = r.readBlock(index)
 		if err != nil {
 			return
 		}
-		cur++
-		var ok bool
-		for {
-			dat, ok = cache[cur]
-			if !ok {
-				break
-			}
-			tmpN, err = w.Write(dat.data)
-			n += int64(tmpN)
-			if err != nil {
-				return
-			}
-			cur++
+		blockOff := pos % int64(r.blockSize)
+		if blockOff >= int64(len(dat)) {
+			return n, io.ErrUnexpectedEOF
 		}
+		n += copy(p[n:], dat[blockOff:])
 	}
 	return
 }
@@ -1,34 +1,48 @@
 package data
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"io"
 
 	"github.com/CalebQ42/squashfs/internal/decompress"
+	"github.com/CalebQ42/squashfs/internal/toreader"
+)
+
+var (
+	//ErrBlockSize is returned when a data block is larger then the archive's block size, compressed or not.
+	ErrBlockSize = errors.New("data block is larger then the block size")
 )
 
 type Reader struct {
+	r          io.ReaderAt
 	master     io.Reader
 	cur        io.Reader
-	fragRdr    io.Reader
+	fragRdr    func() (io.Reader, error)
 	d          decompress.Decompressor
 	comRdr     io.Reader
 	blockSizes []uint32
+	start      uint64
+	size       int64
+	pos        int64
+	block      int
 	blockSize  uint32
-	resetable  bool
 }
 
-func NewReader(r io.Reader, d decompress.Decompressor, blockSizes []uint32, blockSize uint32) *Reader {
+func NewReader(r io.ReaderAt, start uint64, d decompress.Decompressor, blockSizes []uint32, blockSize uint32, size uint64) *Reader {
 	return &Reader{
+		r:          r,
+		master:     toreader.NewReader(r, int64(start)),
 		d:          d,
-		master:     r,
+		start:      start,
 		blockSizes: blockSizes,
 		blockSize:  blockSize,
-		resetable:  true,
+		size:       int64(size),
 	}
 }
 
-func (r *Reader) AddFragment(rdr io.Reader) {
+//AddFragment sets the function used to get the fragment data at the end of the file.
+func (r *Reader) AddFragment(rdr func() (io.Reader, error)) {
 	r.fragRdr = rdr
 	r.blockSizes = append(r.blockSizes, 0)
 }
@@ -37,22 +51,37 @@ func realSize(siz uint32) uint32 {
 	return siz &^ (1 << 24)
 }
 
-func (r *Reader) advance() (err error) {
+func (r *Reader) closeCur() {
 	if clr, ok := r.cur.(io.Closer); ok {
 		clr.Close()
 	}
-	if len(r.blockSizes) == 0 {
-		return io.EOF
+	r.cur = nil
+}
+
+func (r *Reader) advance() (err error) {
+	r.closeCur()
+	if r.block >= len(r.blockSizes) {
+		return io.ErrUnexpectedEOF
 	}
-	if len(r.blockSizes) == 1 && r.fragRdr != nil {
-		r.cur = r.fragRdr
+	if r.block == len(r.blockSizes)-1 && r.fragRdr != nil {
+		r.cur, err = r.fragRdr()
+		if err != nil {
+			return
+		}
 	} else {
-		size := realSize(r.blockSizes[0])
+		size := realSize(r.blockSizes[r.block])
 		if size == 0 {
-			r.cur = bytes.NewReader(make([]byte, r.blockSize))
+			//Holes are a full block, unless they're at the end of the file.
+			hole := r.size - int64(r.block)*int64(r.blockSize)
+			if hole > int64(r.blockSize) {
+				hole = int64(r.blockSize)
+			}
+			r.cur = io.LimitReader(zeroReader{}, hole)
+		} else if size > r.blockSize {
+			return ErrBlockSize
 		} else {
 			r.cur = io.LimitReader(r.master, int64(size))
-			if size == r.blockSizes[0] {
+			if size == r.blockSizes[r.block] {
 				if r.d.Resetable() {
 					if r.comRdr == nil {
 						r.cur, err = r.d.Reader(r.cur)
@@ -69,30 +98,94 @@ func (r *Reader) advance() (err error) {
 			}
 		}
 	}
-	r.blockSizes = r.blockSizes[1:]
+	r.block++
 	return
 }
 
 func (r *Reader) Read(p []byte) (n int, err error) {
-	if r.cur == nil {
-		err = r.advance()
-		if err != nil {
-			return
-		}
+	if r.pos >= r.size {
+		return 0, io.EOF
 	}
-	n, err = r.cur.Read(p)
-	if err == io.EOF {
-		err = r.advance()
-		if err != nil {
-			return
-		}
-		var tmpN int
-		tmp := make([]byte, len(p)-n)
-		tmpN, err = r.Read(tmp)
-		for i := range tmp {
-			p[n+i] = tmp[i]
+	if int64(len(p)) > r.size-r.pos {
+		p = p[:r.size-r.pos]
+	}
+	var tmpN int
+	for n < len(p) {
+		if r.cur == nil {
+			err = r.advance()
+			if err != nil {
+				break
+			}
 		}
+		tmpN, err = r.cur.Read(p[n:])
 		n += tmpN
+		if err == io.EOF {
+			r.closeCur()
+			err = nil
+		} else if err != nil {
+			break
+		}
 	}
+	r.pos += int64(n)
 	return
 }
+
+//Seek sets the position of the next Read. Only the block containing the new position is decompressed.
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return r.pos, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return r.pos, errors.New("negative position")
+	}
+	r.closeCur()
+	r.pos = offset
+	if offset >= r.size {
+		r.block = len(r.blockSizes)
+		return offset, nil
+	}
+	r.block = int(offset / int64(r.blockSize))
+	if r.block >= len(r.blockSizes) {
+		return offset, io.ErrUnexpectedEOF
+	}
+	start := r.start
+	for _, s := range r.blockSizes[:r.block] {
+		start += uint64(realSize(s))
+	}
+	r.master = toreader.NewReader(r.r, int64(start))
+	err := r.advance()
+	if err != nil {
+		return offset, err
+	}
+	_, err = io.CopyN(io.Discard, r.cur, offset%int64(r.blockSize))
+	return offset, err
+}
+
+//ctxReader returns ctx.Err() once ctx is done, so decompression stops part way through a block.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+//zeroReader reads an endless stream of zeros.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
@@ -0,0 +1,108 @@
+package data_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/CalebQ42/squashfs/internal/data"
+	"github.com/CalebQ42/squashfs/internal/decompress"
+)
+
+const blockSize = 4096
+
+func FuzzReader(f *testing.F) {
+	var dat bytes.Buffer
+	zw := zlib.NewWriter(&dat)
+	zw.Write(bytes.Repeat([]byte("data"), blockSize/4))
+	zw.Close()
+	//A compressed block, a hole, then an uncompressed block.
+	sizes := make([]byte, 12)
+	binary.LittleEndian.PutUint32(sizes, uint32(dat.Len()))
+	dat.WriteString("uncompressed")
+	binary.LittleEndian.PutUint32(sizes[8:], 12|1<<24)
+	f.Add(dat.Bytes(), sizes, uint64(2*blockSize+12), int64(blockSize-1))
+	f.Fuzz(func(t *testing.T, dat, rawSizes []byte, size uint64, off int64) {
+		blockSizes := make([]uint32, len(rawSizes)/4)
+		for i := range blockSizes {
+			blockSizes[i] = binary.LittleEndian.Uint32(rawSizes[i*4:])
+		}
+		//The number of blocks is always calculated from the size.
+		if max := uint64(len(blockSizes)) * blockSize; size > max {
+			size = max
+		}
+		r := bytes.NewReader(dat)
+		full := data.NewFullReader(r, 0, decompress.GZip{}, blockSizes, blockSize, size)
+		full.WriteTo(io.Discard)
+		full.ReadAt(make([]byte, 100), off)
+		rdr := data.NewReader(r, 0, decompress.GZip{}, blockSizes, blockSize, size)
+		io.Copy(io.Discard, rdr)
+		rdr.Seek(off, io.SeekStart)
+		io.Copy(io.Discard, rdr)
+	})
+}
+
+//countingGZip is a GZip decompressor that records the most blocks it's decompressed at once.
+type countingGZip struct {
+	decompress.GZip
+	active, max *int32
+}
+
+func (c countingGZip) Reader(src io.Reader) (io.ReadCloser, error) {
+	n := atomic.AddInt32(c.active, 1)
+	for {
+		max := atomic.LoadInt32(c.max)
+		if n <= max || atomic.CompareAndSwapInt32(c.max, max, n) {
+			break
+		}
+	}
+	//Slow enough that blocks overlap if they aren't limited.
+	time.Sleep(5 * time.Millisecond)
+	rdr, err := c.GZip.Reader(src)
+	if err != nil {
+		atomic.AddInt32(c.active, -1)
+		return nil, err
+	}
+	return countingCloser{rdr, c.active}, nil
+}
+
+type countingCloser struct {
+	io.ReadCloser
+	active *int32
+}
+
+func (c countingCloser) Close() error {
+	atomic.AddInt32(c.active, -1)
+	return c.ReadCloser.Close()
+}
+
+func TestWriteToWorkers(t *testing.T) {
+	var dat bytes.Buffer
+	zw := zlib.NewWriter(&dat)
+	zw.Write(bytes.Repeat([]byte("data"), blockSize/4))
+	zw.Close()
+	blocks := 4*runtime.NumCPU() + 4
+	blockSizes := make([]uint32, blocks)
+	all := bytes.Repeat(dat.Bytes(), blocks)
+	for i := range blockSizes {
+		blockSizes[i] = uint32(dat.Len())
+	}
+	var active, max int32
+	full := data.NewFullReader(bytes.NewReader(all), 0, countingGZip{active: &active, max: &max}, blockSizes, blockSize, uint64(blocks*blockSize))
+	n, err := full.WriteTo(io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(blocks*blockSize) {
+		t.Errorf("wrote %d bytes, want %d", n, blocks*blockSize)
+	}
+	//Each CPU's worker, and the caller, can be decompressing a block.
+	if int(max) > runtime.NumCPU()+1 {
+		t.Errorf("%d blocks were decompressed at once with %d CPUs", max, runtime.NumCPU())
+	}
+}
@@ -1,20 +1,36 @@
 package decompress
 
 import (
+	"bytes"
 	"io"
 
 	"github.com/pierrec/lz4/v4"
 )
 
+//Lz4 decompresses raw lz4 blocks (the legacy format used by squashfs), not lz4 frames.
 type Lz4 struct{}
 
+//maxLz4Block is the largest block squashfs allows.
+const maxLz4Block = 1 << 20
+
 func (l Lz4) Reader(r io.Reader) (io.ReadCloser, error) {
-	return io.NopCloser(lz4.NewReader(r)), nil
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	//The uncompressed size isn't stored, so grow the buffer until it fits.
+	for size := 8192; ; size *= 2 {
+		dst := make([]byte, size)
+		n, err := lz4.UncompressBlock(src, dst)
+		if err == nil {
+			return io.NopCloser(bytes.NewReader(dst[:n])), nil
+		}
+		if err != lz4.ErrInvalidSourceShortBuffer || size >= maxLz4Block {
+			return nil, err
+		}
+	}
 }
 
-func (l Lz4) Resetable() bool { return true }
+func (l Lz4) Resetable() bool { return false }
 
-func (l Lz4) Reset(old, src io.Reader) error {
-	old.(*lz4.Reader).Reset(src)
-	return nil
-}
+func (l Lz4) Reset(old, src io.Reader) error { return ErrNotResetable }
@@ -6,10 +6,13 @@ import (
 	"github.com/therootcompany/xz"
 )
 
-type Xz struct{}
+type Xz struct {
+	//DictSize is the largest dictionary that will be allowed. If 0, xz.DefaultDictMax is used.
+	DictSize uint32
+}
 
 func (x Xz) Reader(r io.Reader) (io.ReadCloser, error) {
-	rdr, err := xz.NewReader(r, 0)
+	rdr, err := xz.NewReader(r, x.DictSize)
 	return io.NopCloser(rdr), err
 }
 
@@ -2,28 +2,34 @@ package decompress
 
 import (
 	"io"
+	"sync"
 
 	"github.com/klauspost/compress/zstd"
 )
 
 type Zstd struct {
 	writeToReader *zstd.Decoder
+	once          sync.Once
 }
 
-func (z Zstd) Reader(src io.Reader) (io.ReadCloser, error) {
+func (z *Zstd) Reader(src io.Reader) (io.ReadCloser, error) {
 	r, err := zstd.NewReader(src)
 	return r.IOReadCloser(), err
 }
 
-func (z Zstd) Resetable() bool { return true }
+func (z *Zstd) Resetable() bool { return true }
 
-func (z Zstd) Reset(old, src io.Reader) error {
+func (z *Zstd) Reset(old, src io.Reader) error {
 	return old.(*zstd.Decoder).Reset(src)
 }
 
+//maxZstdBlock is the largest block squashfs allows. Decode refuses to allocate more then this.
+const maxZstdBlock = 1 << 20
+
+//Decode decompresses a single block in one go.
 func (z *Zstd) Decode(in []byte) (out []byte, err error) {
-	if z.writeToReader == nil {
-		z.writeToReader, _ = zstd.NewReader(nil)
-	}
+	z.once.Do(func() {
+		z.writeToReader, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxZstdBlock))
+	})
 	return z.writeToReader.DecodeAll(in, nil)
 }
@@ -3,7 +3,16 @@ package directory
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
+	"strconv"
+	"strings"
+)
+
+var (
+	//ErrInvalid is returned, wrapped with details, when a directory listing can't possibly be valid.
+	ErrInvalid = errors.New("invalid directory listing")
 )
 
 type header struct {
@@ -27,6 +36,7 @@ type entry struct {
 type Entry struct {
 	Name       string
 	BlockStart uint32
+	Num        uint32
 	Type       uint16
 	Offset     uint16
 }
@@ -36,33 +46,56 @@ func readEntry(r io.Reader) (e entry, err error) {
 	if err != nil {
 		return
 	}
+	//Names are limited to 256 bytes.
+	if e.NameSize > 255 {
+		return e, fmt.Errorf("%w: entry name is %d bytes", ErrInvalid, int(e.NameSize)+1)
+	}
 	e.Name = make([]byte, e.NameSize+1)
-	err = binary.Read(r, binary.LittleEndian, &e.Name)
+	_, err = io.ReadFull(r, e.Name)
 	return
 }
 
+//readHeader reads a header, making sure it has at most 256 entries.
+//Returns io.EOF if there are no more headers.
+func readHeader(r io.Reader) (h header, err error) {
+	err = binary.Read(r, binary.LittleEndian, &h)
+	if err != nil {
+		return
+	}
+	if h.Entries > 255 {
+		err = fmt.Errorf("%w: header has %d entries", ErrInvalid, uint64(h.Entries)+1)
+	}
+	return
+}
+
+//readListing reads the listing, without the three bytes included in size. The size isn't trusted, so memory is only allocated as data is actually read.
+func readListing(rdr io.Reader, size uint32) (*bytes.Reader, error) {
+	if size < 3 {
+		return nil, fmt.Errorf("%w: size %d is smaller then 3", ErrInvalid, size)
+	}
+	dat, err := io.ReadAll(io.LimitReader(rdr, int64(size-3)))
+	if err == nil && len(dat) != int(size-3) {
+		err = io.ErrUnexpectedEOF
+	}
+	return bytes.NewReader(dat), err
+}
+
 func ReadEntries(rdr io.Reader, size uint32) (e []Entry, err error) {
-	dat := make([]byte, size-3)
-	rdr.Read(dat)
-	r := bytes.NewReader(dat)
+	r, err := readListing(rdr, size)
+	if err != nil {
+		return
+	}
 	var h header
 	var en entry
 	for {
-		err = binary.Read(r, binary.LittleEndian, &h)
+		h, err = readHeader(r)
 		if err == io.EOF {
 			err = nil
 			return
 		} else if err != nil {
 			return
 		}
-		h.Entries++
-		for i := 0; i < int(h.Entries); i++ {
-			if i != 0 && i%256 == 0 {
-				err = binary.Read(r, binary.LittleEndian, &h)
-				if err != nil {
-					return
-				}
-			}
+		for i := 0; i <= int(h.Entries); i++ {
 			en, err = readEntry(r)
 			if err != nil {
 				return
@@ -70,6 +103,88 @@ func ReadEntries(rdr io.Reader, size uint32) (e []Entry, err error) {
 			e = append(e, Entry{
 				Name:       string(en.Name),
 				BlockStart: h.InodeStart,
+				Num:        uint32(int64(h.Num) + int64(en.NumOffset)),
+				Type:       en.Type,
+				Offset:     en.Offset,
+			})
+		}
+	}
+}
+
+//Find searches a directory listing for the entry called name. size is how much of the listing is left in rdr.
+//Since entries are sorted, reading stops as soon as name would have been found.
+func Find(rdr io.Reader, size uint32, name string) (e Entry, found bool, err error) {
+	r := io.LimitReader(rdr, int64(size))
+	var h header
+	var en entry
+	for {
+		h, err = readHeader(r)
+		if err == io.EOF {
+			return e, false, nil
+		} else if err != nil {
+			return
+		}
+		for i := 0; i <= int(h.Entries); i++ {
+			en, err = readEntry(r)
+			if err != nil {
+				return
+			}
+			if string(en.Name) > name {
+				return e, false, nil
+			} else if string(en.Name) == name {
+				return Entry{
+					Name:       name,
+					BlockStart: h.InodeStart,
+					Num:        uint32(int64(h.Num) + int64(en.NumOffset)),
+					Type:       en.Type,
+					Offset:     en.Offset,
+				}, true, nil
+			}
+		}
+	}
+}
+
+//Check reads a directory listing like ReadEntries, but reports problems with it instead of stopping at them.
+//Entries are returned even if they have problems.
+func Check(rdr io.Reader, size uint32) (e []Entry, problems []string, err error) {
+	if size < 3 {
+		return nil, []string{"directory size " + strconv.Itoa(int(size)) + " is smaller then 3"}, nil
+	}
+	r, err := readListing(rdr, size)
+	if err != nil {
+		return
+	}
+	var h header
+	var en entry
+	for {
+		err = binary.Read(r, binary.LittleEndian, &h)
+		if err == io.EOF {
+			return e, problems, nil
+		} else if err != nil {
+			return
+		}
+		if h.Entries >= 256 {
+			problems = append(problems, "directory header has "+strconv.Itoa(int(h.Entries)+1)+" entries, more then 256")
+		}
+		for i := 0; i <= int(h.Entries); i++ {
+			en, err = readEntry(r)
+			if err != nil {
+				return
+			}
+			name := string(en.Name)
+			if name == "." || name == ".." || strings.ContainsAny(name, "/\x00") {
+				problems = append(problems, "invalid entry name "+strconv.Quote(name))
+			}
+			if len(e) > 0 && name <= e[len(e)-1].Name {
+				problems = append(problems, "entry "+strconv.Quote(name)+" is out of order after "+strconv.Quote(e[len(e)-1].Name))
+			}
+			if en.Type < 1 || en.Type > 7 {
+				problems = append(problems, "entry "+strconv.Quote(name)+" has invalid type "+strconv.Itoa(int(en.Type)))
+			}
+			e = append(e, Entry{
+				Name:       name,
+				BlockStart: h.InodeStart,
+				Num:        uint32(int64(h.Num) + int64(en.NumOffset)),
 				Type:       en.Type,
 				Offset:     en.Offset,
 			})
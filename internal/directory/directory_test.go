@@ -0,0 +1,25 @@
+package directory_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/CalebQ42/squashfs/internal/directory"
+)
+
+func FuzzReadEntries(f *testing.F) {
+	//A header with two entries, "a" and "b".
+	f.Add([]byte{
+		1, 0, 0, 0, 0, 0, 0, 0, 5, 0, 0, 0,
+		0, 0, 0, 0, 1, 0, 0, 0, 'a',
+		32, 0, 1, 0, 2, 0, 0, 0, 'b',
+	}, "b")
+	f.Fuzz(func(t *testing.T, dat []byte, name string) {
+		size := uint32(len(dat) + 3)
+		directory.ReadEntries(bytes.NewReader(dat), size)
+		directory.Find(bytes.NewReader(dat), size, name)
+		directory.Check(bytes.NewReader(dat), size)
+		//The size given can't be trusted either.
+		directory.ReadEntries(bytes.NewReader(dat), size*1024)
+	})
+}
@@ -0,0 +1,61 @@
+package directory
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+)
+
+//Index marks a header that starts in a new metadata block.
+type Index struct {
+	Name string
+	//Ind is the offset of the header from the start of the directory listing.
+	Ind uint32
+}
+
+//WriteEntries encodes a sorted directory listing.
+//start is the listing's uncompressed location in the directory table and is used to split headers at metadata block boundaries.
+//blockSize is the size of an uncompressed metadata block.
+func WriteEntries(ents []Entry, start uint32, blockSize uint32) (dat []byte, idx []Index) {
+	var buf bytes.Buffer
+	var h header
+	var countPos int
+	count := 0
+	for i, e := range ents {
+		pos := uint32(buf.Len())
+		delta := int64(e.Num) - int64(h.Num)
+		if count == 0 || count == 256 || e.BlockStart != h.InodeStart ||
+			delta > math.MaxInt16 || delta < math.MinInt16 ||
+			(start+pos)/blockSize != (start+uint32(countPos))/blockSize {
+			if count > 0 {
+				binary.LittleEndian.PutUint32(buf.Bytes()[countPos:], uint32(count-1))
+				if i > 0 && (start+pos)/blockSize != (start+uint32(countPos))/blockSize {
+					idx = append(idx, Index{
+						Name: e.Name,
+						Ind:  pos,
+					})
+				}
+			}
+			h = header{
+				InodeStart: e.BlockStart,
+				Num:        e.Num,
+			}
+			countPos = int(pos)
+			count = 0
+			binary.Write(&buf, binary.LittleEndian, h)
+			delta = 0
+		}
+		binary.Write(&buf, binary.LittleEndian, entryInit{
+			Offset:    e.Offset,
+			NumOffset: int16(delta),
+			Type:      e.Type,
+			NameSize:  uint16(len(e.Name) - 1),
+		})
+		buf.WriteString(e.Name)
+		count++
+	}
+	if count > 0 {
+		binary.LittleEndian.PutUint32(buf.Bytes()[countPos:], uint32(count-1))
+	}
+	return buf.Bytes(), idx
+}
@@ -2,6 +2,7 @@ package inode
 
 import (
 	"encoding/binary"
+	"fmt"
 	"io"
 )
 
@@ -49,14 +50,43 @@ func ReadEDir(r io.Reader) (d EDirectory, err error) {
 	if err != nil {
 		return
 	}
-	d.Indexes = make([]DirectoryIndex, d.IndCount)
+	var idx DirectoryIndex
+	for i := 0; i < int(d.IndCount); i++ {
+		err = binary.Read(r, binary.LittleEndian, &idx.directoryIndexInit)
+		if err != nil {
+			return
+		}
+		//Names are limited to 256 bytes.
+		if idx.NameSize > 255 {
+			return d, fmt.Errorf("%w: directory index name is %d bytes", ErrInvalid, uint64(idx.NameSize)+1)
+		}
+		idx.Name = make([]byte, idx.NameSize+1)
+		_, err = io.ReadFull(r, idx.Name)
+		if err != nil {
+			return
+		}
+		d.Indexes = append(d.Indexes, idx)
+	}
+	return
+}
+
+func WriteDir(w io.Writer, d Directory) error {
+	return binary.Write(w, binary.LittleEndian, d)
+}
+
+func WriteEDir(w io.Writer, d EDirectory) (err error) {
+	d.IndCount = uint16(len(d.Indexes))
+	err = binary.Write(w, binary.LittleEndian, d.eDirectoryInit)
+	if err != nil {
+		return
+	}
 	for i := range d.Indexes {
-		err = binary.Read(r, binary.LittleEndian, &d.Indexes[i].directoryIndexInit)
+		d.Indexes[i].NameSize = uint32(len(d.Indexes[i].Name) - 1)
+		err = binary.Write(w, binary.LittleEndian, d.Indexes[i].directoryIndexInit)
 		if err != nil {
 			return
 		}
-		d.Indexes[i].Name = make([]byte, d.Indexes[i].NameSize+1)
-		err = binary.Read(r, binary.LittleEndian, &d.Indexes[i].Name)
+		_, err = w.Write(d.Indexes[i].Name)
 		if err != nil {
 			return
 		}
@@ -2,6 +2,7 @@ package inode
 
 import (
 	"encoding/binary"
+	"fmt"
 	"io"
 	"math"
 )
@@ -38,12 +39,11 @@ func ReadFile(r io.Reader, blockSize uint32) (f File, err error) {
 	if err != nil {
 		return
 	}
-	toRead := int(math.Floor(float64(f.Size) / float64(blockSize)))
+	toRead := uint64(f.Size / blockSize)
 	if f.FragInd == 0xFFFFFFFF && f.Size%blockSize > 0 {
 		toRead++
 	}
-	f.BlockSizes = make([]uint32, toRead)
-	err = binary.Read(r, binary.LittleEndian, &f.BlockSizes)
+	f.BlockSizes, err = readBlockSizes(r, toRead)
 	return
 }
 
@@ -52,11 +52,42 @@ func ReadEFile(r io.Reader, blockSize uint32) (f EFile, err error) {
 	if err != nil {
 		return
 	}
-	toRead := int(math.Floor(float64(f.Size) / float64(blockSize)))
+	toRead := f.Size / uint64(blockSize)
 	if f.FragInd == 0xFFFFFFFF && f.Size%uint64(blockSize) > 0 {
 		toRead++
 	}
-	f.BlockSizes = make([]uint32, toRead)
-	err = binary.Read(r, binary.LittleEndian, &f.BlockSizes)
+	f.BlockSizes, err = readBlockSizes(r, toRead)
 	return
 }
+
+//readBlockSizes reads count block sizes. Since count is calculated from the file's size, it's not trusted.
+func readBlockSizes(r io.Reader, count uint64) ([]uint32, error) {
+	if count > math.MaxInt64/4 {
+		return nil, fmt.Errorf("%w: file has %d blocks", ErrInvalid, count)
+	}
+	dat, err := readBytes(r, count*4)
+	if err != nil {
+		return nil, err
+	}
+	sizes := make([]uint32, count)
+	for i := range sizes {
+		sizes[i] = binary.LittleEndian.Uint32(dat[i*4:])
+	}
+	return sizes, nil
+}
+
+func WriteFile(w io.Writer, f File) (err error) {
+	err = binary.Write(w, binary.LittleEndian, f.fileInit)
+	if err != nil {
+		return
+	}
+	return binary.Write(w, binary.LittleEndian, f.BlockSizes)
+}
+
+func WriteEFile(w io.Writer, f EFile) (err error) {
+	err = binary.Write(w, binary.LittleEndian, f.eFileInit)
+	if err != nil {
+		return
+	}
+	return binary.Write(w, binary.LittleEndian, f.BlockSizes)
+}
@@ -3,8 +3,13 @@ package inode
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
-	"strconv"
+)
+
+var (
+	//ErrInvalid is returned, wrapped with details, when an inode can't possibly be valid.
+	ErrInvalid = errors.New("invalid inode")
 )
 
 const (
@@ -39,6 +44,9 @@ type Inode struct {
 }
 
 func Read(r io.Reader, blockSize uint32) (i Inode, err error) {
+	if blockSize == 0 {
+		return i, fmt.Errorf("%w: block size is 0", ErrInvalid)
+	}
 	err = binary.Read(r, binary.LittleEndian, &i.Header)
 	if err != nil {
 		return
@@ -73,7 +81,42 @@ func Read(r io.Reader, blockSize uint32) (i Inode, err error) {
 	case ESock:
 		i.Data, err = ReadEIPC(r)
 	default:
-		return i, errors.New("invalid inode type " + strconv.Itoa(int(i.Type)))
+		return i, fmt.Errorf("%w: type %d", ErrInvalid, i.Type)
+	}
+	return
+}
+
+//readBytes reads n bytes from r. n comes from the archive, so memory is only allocated as data is actually read.
+func readBytes(r io.Reader, n uint64) ([]byte, error) {
+	dat, err := io.ReadAll(io.LimitReader(r, int64(n)))
+	if err == nil && uint64(len(dat)) != n {
+		err = io.ErrUnexpectedEOF
+	}
+	return dat, err
+}
+
+func Write(w io.Writer, i Inode) (err error) {
+	err = binary.Write(w, binary.LittleEndian, i.Header)
+	if err != nil {
+		return
+	}
+	switch d := i.Data.(type) {
+	case Directory:
+		err = WriteDir(w, d)
+	case EDirectory:
+		err = WriteEDir(w, d)
+	case File:
+		err = WriteFile(w, d)
+	case EFile:
+		err = WriteEFile(w, d)
+	case Symlink:
+		err = WriteSym(w, d)
+	case ESymlink:
+		err = WriteESym(w, d)
+	case Device, EDevice, IPC, EIPC:
+		err = binary.Write(w, binary.LittleEndian, d)
+	default:
+		return fmt.Errorf("%w: type %d", ErrInvalid, i.Type)
 	}
 	return
 }
@@ -0,0 +1,32 @@
+package inode_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/CalebQ42/squashfs/internal/inode"
+)
+
+func FuzzRead(f *testing.F) {
+	seeds := []inode.Inode{
+		{Header: inode.Header{Type: inode.Sym, Num: 1}, Data: inode.Symlink{Target: []byte("target")}},
+		{Header: inode.Header{Type: inode.Char, Num: 2}, Data: inode.Device{LinkCount: 1, Dev: 0x501}},
+		{Header: inode.Header{Type: inode.EDir, Num: 3}, Data: inode.EDirectory{Indexes: []inode.DirectoryIndex{{Name: []byte("name")}}}},
+	}
+	var file inode.File
+	file.Size = 10000
+	file.FragInd = 0xFFFFFFFF
+	file.BlockSizes = []uint32{100, 200, 300}
+	seeds = append(seeds, inode.Inode{Header: inode.Header{Type: inode.Fil, Num: 4}, Data: file})
+	for _, i := range seeds {
+		var buf bytes.Buffer
+		err := inode.Write(&buf, i)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(buf.Bytes(), uint32(4096))
+	}
+	f.Fuzz(func(t *testing.T, dat []byte, blockSize uint32) {
+		inode.Read(bytes.NewReader(dat), blockSize)
+	})
+}
@@ -26,8 +26,7 @@ func ReadSym(r io.Reader) (s Symlink, err error) {
 	if err != nil {
 		return
 	}
-	s.Target = make([]byte, s.TargetSize)
-	err = binary.Read(r, binary.LittleEndian, &s.Target)
+	s.Target, err = readBytes(r, uint64(s.TargetSize))
 	return
 }
 
@@ -36,11 +35,33 @@ func ReadESym(r io.Reader) (s ESymlink, err error) {
 	if err != nil {
 		return
 	}
-	s.Target = make([]byte, s.TargetSize)
-	err = binary.Read(r, binary.LittleEndian, &s.Target)
+	s.Target, err = readBytes(r, uint64(s.TargetSize))
 	if err != nil {
 		return
 	}
 	err = binary.Read(r, binary.LittleEndian, &s.XattrInd)
 	return
 }
+
+func WriteSym(w io.Writer, s Symlink) (err error) {
+	s.TargetSize = uint32(len(s.Target))
+	err = binary.Write(w, binary.LittleEndian, s.symlinkInit)
+	if err != nil {
+		return
+	}
+	_, err = w.Write(s.Target)
+	return
+}
+
+func WriteESym(w io.Writer, s ESymlink) (err error) {
+	s.TargetSize = uint32(len(s.Target))
+	err = binary.Write(w, binary.LittleEndian, s.symlinkInit)
+	if err != nil {
+		return
+	}
+	_, err = w.Write(s.Target)
+	if err != nil {
+		return
+	}
+	return binary.Write(w, binary.LittleEndian, s.XattrInd)
+}
@@ -0,0 +1,72 @@
+package metadata
+
+import (
+	"container/list"
+	"sync"
+)
+
+//Cache is a concurrency safe, least recently used, cache of decompressed metadata blocks, keyed by their location in the archive.
+//A nil *Cache is valid and caches nothing.
+type Cache struct {
+	blocks map[uint64]*list.Element
+	lru    *list.List
+	mut    sync.Mutex
+	max    int
+}
+
+type block struct {
+	data []byte
+	next uint64
+}
+
+type cacheEntry struct {
+	block
+	offset uint64
+}
+
+//NewCache creates a Cache that holds up to size blocks. If size <= 0, returns nil.
+func NewCache(size int) *Cache {
+	if size <= 0 {
+		return nil
+	}
+	return &Cache{
+		blocks: make(map[uint64]*list.Element),
+		lru:    list.New(),
+		max:    size,
+	}
+}
+
+func (c *Cache) get(offset uint64) (block, bool) {
+	if c == nil {
+		return block{}, false
+	}
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	el, ok := c.blocks[offset]
+	if !ok {
+		return block{}, false
+	}
+	c.lru.MoveToFront(el)
+	return el.Value.(*cacheEntry).block, true
+}
+
+func (c *Cache) add(offset uint64, b block) {
+	if c == nil {
+		return
+	}
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if el, ok := c.blocks[offset]; ok {
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.blocks[offset] = c.lru.PushFront(&cacheEntry{
+		block:  b,
+		offset: offset,
+	})
+	for c.lru.Len() > c.max {
+		el := c.lru.Back()
+		c.lru.Remove(el)
+		delete(c.blocks, el.Value.(*cacheEntry).offset)
+	}
+}
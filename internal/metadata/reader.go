@@ -2,22 +2,36 @@ package metadata
 
 import (
 	"encoding/binary"
+	"errors"
 	"io"
 
 	"github.com/CalebQ42/squashfs/internal/decompress"
 )
 
+var (
+	//ErrBlockSize is returned when a metadata block is larger then BlockSize, compressed or not.
+	ErrBlockSize = errors.New("metadata block is larger then 8KiB")
+)
+
+//Reader reads consecutive metadata blocks, starting at a given offset inside the first block.
 type Reader struct {
-	master io.Reader
-	cur    io.Reader
-	d      decompress.Decompressor
-	comRdr io.Reader
+	r     io.ReaderAt
+	d     decompress.Decompressor
+	cache *Cache
+	cur   []byte
+	next  uint64
+	skip  uint16
 }
 
-func NewReader(master io.Reader, d decompress.Decompressor) *Reader {
+//NewReader creates a Reader that reads the metadata blocks starting at the block at start, skipping the first offset bytes of uncompressed data.
+//If cache isn't nil, decompressed blocks are taken from, and added to, cache.
+func NewReader(r io.ReaderAt, start uint64, offset uint16, d decompress.Decompressor, cache *Cache) *Reader {
 	return &Reader{
-		master: master,
-		d:      d,
+		r:     r,
+		d:     d,
+		cache: cache,
+		next:  start,
+		skip:  offset,
 	}
 }
 
@@ -25,57 +39,93 @@ func realSize(siz uint16) uint16 {
 	return siz &^ 0x8000
 }
 
-func (r *Reader) advance() (err error) {
-	if !r.d.Resetable() {
-		if clr, ok := r.cur.(io.Closer); ok {
-			clr.Close()
-		}
-	}
-	var raw uint16
-	err = binary.Read(r.master, binary.LittleEndian, &raw)
+//readBlock reads and decompresses the metadata block at offset.
+func readBlock(r io.ReaderAt, offset uint64, d decompress.Decompressor) (b block, err error) {
+	var head [2]byte
+	_, err = r.ReadAt(head[:], int64(offset))
 	if err != nil {
 		return
 	}
+	raw := binary.LittleEndian.Uint16(head[:])
 	size := realSize(raw)
-	r.cur = io.LimitReader(r.master, int64(size))
-	if size == raw {
-		if r.d.Resetable() {
-			if r.comRdr == nil {
-				r.cur, err = r.d.Reader(r.cur)
-				if err != nil {
-					return
-				}
-			} else {
-				err = r.d.Reset(r.comRdr, r.cur)
-				r.cur = r.comRdr
-			}
-		} else {
-			r.cur, err = r.d.Reader(r.cur)
+	if size > BlockSize {
+		return b, ErrBlockSize
+	}
+	b.next = offset + 2 + uint64(size)
+	b.data = make([]byte, size)
+	_, err = r.ReadAt(b.data, int64(offset)+2)
+	if err == io.EOF && size == 0 {
+		err = nil
+	}
+	if err != nil || size != raw {
+		return
+	}
+	if zstd, ok := d.(*decompress.Zstd); ok {
+		b.data, err = zstd.Decode(b.data)
+	} else {
+		var rdr io.ReadCloser
+		rdr, err = d.Reader(&byteReader{dat: b.data})
+		if err != nil {
+			return
 		}
+		defer rdr.Close()
+		//Read one byte past the limit to catch blocks that are too large without reading all of them.
+		b.data, err = io.ReadAll(io.LimitReader(rdr, BlockSize+1))
+	}
+	if err == nil && len(b.data) > BlockSize {
+		err = ErrBlockSize
 	}
 	return
 }
 
-func (r *Reader) Read(p []byte) (n int, err error) {
-	if r.cur == nil {
-		err = r.advance()
+func (r *Reader) advance() error {
+	b, ok := r.cache.get(r.next)
+	if !ok {
+		var err error
+		b, err = readBlock(r.r, r.next, r.d)
 		if err != nil {
-			return
+			return err
 		}
+		r.cache.add(r.next, b)
 	}
-	n, err = r.cur.Read(p)
-	if err == io.EOF {
-		err = r.advance()
-		if err != nil {
-			return
+	r.cur = b.data
+	r.next = b.next
+	if r.skip > 0 {
+		if int(r.skip) > len(r.cur) {
+			return io.ErrUnexpectedEOF
 		}
-		var tmpN int
-		tmp := make([]byte, len(p)-n)
-		tmpN, err = r.Read(tmp)
-		for i := 0; i < tmpN; i++ {
-			p[n+i] = tmp[i]
+		r.cur = r.cur[r.skip:]
+		r.skip = 0
+	}
+	return nil
+}
+
+func (r *Reader) Read(p []byte) (n int, err error) {
+	for n < len(p) {
+		if len(r.cur) == 0 {
+			err = r.advance()
+			if err != nil {
+				return
+			}
+			continue
 		}
-		n += tmpN
+		copied := copy(p[n:], r.cur)
+		r.cur = r.cur[copied:]
+		n += copied
 	}
 	return
 }
+
+//byteReader is a bytes.Reader without the extra interfaces, so decompressors don't read past the block.
+type byteReader struct {
+	dat []byte
+}
+
+func (b *byteReader) Read(p []byte) (int, error) {
+	if len(b.dat) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, b.dat)
+	b.dat = b.dat[n:]
+	return n, nil
+}
@@ -0,0 +1,26 @@
+package metadata_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/CalebQ42/squashfs/internal/compress"
+	"github.com/CalebQ42/squashfs/internal/decompress"
+	"github.com/CalebQ42/squashfs/internal/metadata"
+)
+
+func FuzzReader(f *testing.F) {
+	w := metadata.NewWriter(compress.GZip{})
+	w.Write(bytes.Repeat([]byte("metadata"), 2000))
+	dat, err := w.Bytes()
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(dat, uint16(0))
+	f.Add(dat, uint16(100))
+	f.Fuzz(func(t *testing.T, dat []byte, offset uint16) {
+		rdr := metadata.NewReader(bytes.NewReader(dat), 0, offset, decompress.GZip{}, nil)
+		io.Copy(io.Discard, rdr)
+	})
+}
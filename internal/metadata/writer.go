@@ -0,0 +1,105 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/CalebQ42/squashfs/internal/compress"
+)
+
+//BlockSize is the maximum uncompressed size of a metadata block.
+const BlockSize = 8192
+
+//Writer packs data into metadata blocks. Blocks are kept in memory until retrieved with Bytes.
+type Writer struct {
+	c      compress.Compressor
+	out    bytes.Buffer
+	cur    []byte
+	starts []uint32
+	total  int
+}
+
+func NewWriter(c compress.Compressor) *Writer {
+	return &Writer{
+		c:      c,
+		cur:    make([]byte, 0, BlockSize),
+		starts: []uint32{0},
+	}
+}
+
+func (w *Writer) flush() error {
+	if len(w.cur) == 0 {
+		return nil
+	}
+	dat, err := w.c.Compress(w.cur)
+	if err != nil {
+		return err
+	}
+	raw := uint16(len(dat))
+	if len(dat) >= len(w.cur) {
+		dat = w.cur
+		raw = uint16(len(dat)) | 0x8000
+	}
+	err = binary.Write(&w.out, binary.LittleEndian, raw)
+	if err != nil {
+		return err
+	}
+	w.out.Write(dat)
+	w.cur = w.cur[:0]
+	w.starts = append(w.starts, uint32(w.out.Len()))
+	return nil
+}
+
+func (w *Writer) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		toCopy := BlockSize - len(w.cur)
+		if toCopy > len(p) {
+			toCopy = len(p)
+		}
+		w.cur = append(w.cur, p[:toCopy]...)
+		p = p[toCopy:]
+		n += toCopy
+		w.total += toCopy
+		if len(w.cur) == BlockSize {
+			err = w.flush()
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+//Ref returns the location that the next write will be at.
+//block is the offset of the current metadata block from the start of the table and offset is the location inside the uncompressed block.
+func (w *Writer) Ref() (block uint32, offset uint16) {
+	return uint32(w.out.Len()), uint16(len(w.cur))
+}
+
+//Len returns the total amount of uncompressed data written.
+func (w *Writer) Len() int {
+	return w.total
+}
+
+//BlockStart returns the offset of the nth metadata block from the start of the table.
+//Only valid for blocks that have been started.
+func (w *Writer) BlockStart(n int) uint32 {
+	return w.starts[n]
+}
+
+//Blocks returns the number of metadata blocks that have been started.
+func (w *Writer) Blocks() int {
+	if len(w.cur) == 0 {
+		return len(w.starts) - 1
+	}
+	return len(w.starts)
+}
+
+//Bytes flushes any pending data and returns the finished metadata blocks.
+func (w *Writer) Bytes() ([]byte, error) {
+	err := w.flush()
+	if err != nil {
+		return nil, err
+	}
+	return w.out.Bytes(), nil
+}
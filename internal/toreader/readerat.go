@@ -1,23 +1,64 @@
 package toreader
 
-import "io"
+import (
+	"bytes"
+	"io"
+	"os"
+	"runtime"
+)
 
-type ReaderAt struct {
-	d []byte
+//ReaderAt is an io.ReaderAt made from an io.Reader. On Windows, Close must be called to remove any temporary file.
+type ReaderAt interface {
+	io.ReaderAt
+	io.Closer
 }
 
-func NewReaderAt(r io.Reader) (ra ReaderAt, err error) {
-	ra.d, err = io.ReadAll(r)
-	return
+type memReaderAt struct {
+	*bytes.Reader
 }
 
-func (r ReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
-	if int(off) >= len(r.d) {
-		return 0, io.EOF
+func (memReaderAt) Close() error { return nil }
+
+type fileReaderAt struct {
+	*os.File
+	removed bool
+}
+
+func (f fileReaderAt) Close() error {
+	err := f.File.Close()
+	if !f.removed {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+//NewReaderAt reads all of r. If r is no larger then threshold, it's kept in memory.
+//Otherwise it's spooled to a temporary file in dir. If dir is empty, os.TempDir is used.
+//Except on Windows, the temporary file is removed as soon as it's created so it's never left behind, even if Close isn't called.
+func NewReaderAt(r io.Reader, threshold int64, dir string) (ReaderAt, error) {
+	var buf bytes.Buffer
+	_, err := io.CopyN(&buf, r, threshold+1)
+	if err == io.EOF {
+		return memReaderAt{bytes.NewReader(buf.Bytes())}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	fil, err := os.CreateTemp(dir, "squashfs")
+	if err != nil {
+		return nil, err
+	}
+	out := fileReaderAt{File: fil}
+	//Windows can't remove open files.
+	if runtime.GOOS != "windows" {
+		out.removed = os.Remove(fil.Name()) == nil
+	}
+	_, err = buf.WriteTo(fil)
+	if err == nil {
+		_, err = io.Copy(fil, r)
 	}
-	n = copy(p, r.d[off:])
-	if n != len(p) {
-		err = io.EOF
+	if err != nil {
+		out.Close()
+		return nil, err
 	}
-	return
+	return out, nil
 }
@@ -0,0 +1,36 @@
+package toreader
+
+import (
+	"io"
+	"sync"
+)
+
+//SeekerAt is an io.ReaderAt made from an io.ReadSeeker. Offsets are relative to the position of the io.ReadSeeker when it's created.
+type SeekerAt struct {
+	r     io.ReadSeeker
+	mut   *sync.Mutex
+	start int64
+}
+
+func NewSeekerAt(r io.ReadSeeker) (SeekerAt, error) {
+	start, err := r.Seek(0, io.SeekCurrent)
+	return SeekerAt{
+		r:     r,
+		mut:   &sync.Mutex{},
+		start: start,
+	}, err
+}
+
+func (s SeekerAt) ReadAt(p []byte, off int64) (n int, err error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	_, err = s.r.Seek(s.start+off, io.SeekStart)
+	if err != nil {
+		return
+	}
+	n, err = io.ReadFull(s.r, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return
+}
@@ -0,0 +1,155 @@
+package xattr
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+//Prefixes are the namespaces squashfs supports. The index is the prefix's type.
+var Prefixes = []string{"user.", "trusted.", "security."}
+
+const outOfLine = 0x100
+
+type keyInit struct {
+	Type     uint16
+	NameSize uint16
+}
+
+//Pair is a single key/value pair. If the value is stored out of line, Value is nil and Ref points to the value.
+type Pair struct {
+	Name  string
+	Value []byte
+	Ref   uint64
+	OOL   bool
+}
+
+//ID is an entry in the xattr id table.
+type ID struct {
+	Ref   uint64
+	Count uint32
+	Size  uint32
+}
+
+//IDTable is the header of the xattr id table.
+type IDTable struct {
+	Start uint64
+	Count uint32
+	_     uint32
+}
+
+func readKey(r io.Reader) (name string, ool bool, err error) {
+	var k keyInit
+	err = binary.Read(r, binary.LittleEndian, &k)
+	if err != nil {
+		return
+	}
+	prefix := int(k.Type &^ outOfLine)
+	if prefix >= len(Prefixes) {
+		err = errors.New("invalid xattr prefix " + strconv.Itoa(prefix))
+		return
+	}
+	dat := make([]byte, k.NameSize)
+	_, err = io.ReadFull(r, dat)
+	return Prefixes[prefix] + string(dat), k.Type&outOfLine == outOfLine, err
+}
+
+//ReadValue reads a value.
+func ReadValue(r io.Reader) (dat []byte, err error) {
+	var size uint32
+	err = binary.Read(r, binary.LittleEndian, &size)
+	if err != nil {
+		return
+	}
+	//size isn't trusted, so memory is only allocated as data is actually read.
+	dat, err = io.ReadAll(io.LimitReader(r, int64(size)))
+	if err == nil && uint32(len(dat)) != size {
+		err = io.ErrUnexpectedEOF
+	}
+	return
+}
+
+//Read reads count key/value pairs.
+func Read(r io.Reader, count uint32) (p []Pair, err error) {
+	var val []byte
+	var pair Pair
+	for i := uint32(0); i < count; i++ {
+		pair = Pair{}
+		pair.Name, pair.OOL, err = readKey(r)
+		if err != nil {
+			return
+		}
+		val, err = ReadValue(r)
+		if err != nil {
+			return
+		}
+		if pair.OOL {
+			if len(val) != 8 {
+				err = errors.New("out of line xattr value has invalid size")
+				return
+			}
+			pair.Ref = binary.LittleEndian.Uint64(val)
+		} else {
+			pair.Value = val
+		}
+		p = append(p, pair)
+	}
+	return
+}
+
+//Split separates a xattr name into it's prefix type and the rest of the name.
+//Returns false if the prefix isn't supported by squashfs.
+func Split(name string) (uint16, string, bool) {
+	for i, pre := range Prefixes {
+		if strings.HasPrefix(name, pre) {
+			return uint16(i), strings.TrimPrefix(name, pre), true
+		}
+	}
+	return 0, "", false
+}
+
+//WriteKey writes the key portion of a key/value pair.
+func WriteKey(w io.Writer, name string, ool bool) (err error) {
+	typ, name, ok := Split(name)
+	if !ok {
+		return errors.New("unsupported xattr prefix: " + name)
+	}
+	if ool {
+		typ |= outOfLine
+	}
+	err = binary.Write(w, binary.LittleEndian, keyInit{
+		Type:     typ,
+		NameSize: uint16(len(name)),
+	})
+	if err != nil {
+		return
+	}
+	_, err = io.WriteString(w, name)
+	return
+}
+
+//Write writes a key/value pair. If p.OOL is true, p.Ref is written in place of the value.
+func Write(w io.Writer, p Pair) (err error) {
+	err = WriteKey(w, p.Name, p.OOL)
+	if err != nil {
+		return
+	}
+	if p.OOL {
+		ref := make([]byte, 8)
+		binary.LittleEndian.PutUint64(ref, p.Ref)
+		return WriteValue(w, ref)
+	}
+	return WriteValue(w, p.Value)
+}
+
+//WriteValue writes a value.
+func WriteValue(w io.Writer, dat []byte) (err error) {
+	err = binary.Write(w, binary.LittleEndian, uint32(len(dat)))
+	if err != nil {
+		return
+	}
+	_, err = w.Write(dat)
+	return
+}
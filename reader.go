@@ -7,20 +7,27 @@ import (
 	"math"
 	"time"
 
+	"github.com/CalebQ42/squashfs/internal/data"
 	"github.com/CalebQ42/squashfs/internal/decompress"
 	"github.com/CalebQ42/squashfs/internal/directory"
 	"github.com/CalebQ42/squashfs/internal/inode"
 	"github.com/CalebQ42/squashfs/internal/metadata"
 	"github.com/CalebQ42/squashfs/internal/toreader"
+	"github.com/CalebQ42/squashfs/internal/xattr"
 )
 
 type Reader struct {
 	*FS
 	d           decompress.Decompressor
 	r           io.ReaderAt
+	closer      io.Closer
+	cache       *metadata.Cache
 	fragEntries []fragEntry
 	ids         []uint32
 	exportTable []uint64
+	xattrIDs    []xattr.ID
+	xattrStart  uint64
+	compOptions CompressionOptions
 	s           superblock
 }
 
@@ -28,6 +35,22 @@ var (
 	ErrorMagic   = errors.New("magic incorrect. probably not reading squashfs archive")
 	ErrorLog     = errors.New("block log is incorrect. possible corrupted archive")
 	ErrorVersion = errors.New("squashfs version of archive is not 4.0")
+	//ErrorTruncated is returned when the archive is smaller then it's superblock says.
+	ErrorTruncated = errors.New("archive is truncated. possible corrupted archive")
+	//ErrorLoop is returned when a directory contains itself, or one of it's parents.
+	ErrorLoop = errors.New("directory loop. possible corrupted archive")
+	//ErrorTable is returned when a table has more entries then can fit in the archive.
+	ErrorTable = errors.New("table is larger then the archive. possible corrupted archive")
+	//ErrorFragment is returned when a file's fragment doesn't exist or doesn't fit in it's fragment block.
+	ErrorFragment = errors.New("fragment out of range. possible corrupted archive")
+	//ErrorMetadataBlock is returned when a metadata block is larger then 8KiB.
+	ErrorMetadataBlock = metadata.ErrBlockSize
+	//ErrorDataBlock is returned when a data or fragment block is larger then the archive's block size.
+	ErrorDataBlock = data.ErrBlockSize
+	//ErrorInode is returned, wrapped with details, when an inode is invalid. Check with errors.Is.
+	ErrorInode = inode.ErrInvalid
+	//ErrorDirectory is returned, wrapped with details, when a directory listing is invalid. Check with errors.Is.
+	ErrorDirectory = directory.ErrInvalid
 )
 
 const (
@@ -39,144 +62,260 @@ const (
 	ZSTDCompression
 )
 
+//ReaderOptions are options on how a Reader works.
+type ReaderOptions struct {
+	//Decompressor, if set, is used instead of the Decompressor for the archive's compression type.
+	Decompressor Decompressor
+	//MetadataCacheSize is the number of decompressed metadata blocks (up to 8KiB each) that are kept in memory.
+	//The cache is used for inodes, directories, and xattrs. If <= 0, metadata is never cached.
+	MetadataCacheSize int
+	//MemoryThreshold is the largest archive NewReaderFromReader will keep in memory.
+	//Larger archives are written to a temporary file. Has no effect if the io.Reader is also an io.ReaderAt or io.ReadSeeker.
+	MemoryThreshold int64
+	//TempDir is where the temporary file is created if MemoryThreshold is passed. If empty, os.TempDir is used.
+	TempDir string
+}
+
+//DefaultReaderOptions is the default ReaderOptions.
+func DefaultReaderOptions() ReaderOptions {
+	return ReaderOptions{
+		MetadataCacheSize: 256,
+		MemoryThreshold:   64 * 1024 * 1024,
+	}
+}
+
+//NewReaderFromReader creates a Reader from an io.Reader with the default ReaderOptions.
+//The archive is read starting at r's current position, the same as any io.Reader. See NewReaderFromReaderWithOptions.
+//Close should be called once done with the Reader. On Windows, not calling it leaves a temporary file behind.
 func NewReaderFromReader(r io.Reader) (*Reader, error) {
-	rdr, err := toreader.NewReaderAt(r)
+	return NewReaderFromReaderWithOptions(r, DefaultReaderOptions())
+}
+
+//NewReaderFromReaderWithOptions creates a Reader from an io.Reader with the given ReaderOptions.
+//The archive is read starting at r's current position, the same as any io.Reader, even if r is also an io.ReaderAt.
+//If r is an io.ReadSeeker, such as an *os.File, it's used directly. If r is an io.ReaderAt that can't seek, it has no position
+//and the archive is read from offset 0, the same as NewReaderWithOptions.
+//Otherwise r is read completely, either into memory or a temporary file depending on op.MemoryThreshold.
+//The temporary file is removed as soon as it's created, except on Windows, where it's only removed by Close.
+//Close should be called once done with the Reader to release the temporary file.
+func NewReaderFromReaderWithOptions(r io.Reader, op ReaderOptions) (*Reader, error) {
+	switch rdr := r.(type) {
+	case io.ReadSeeker:
+		at, ok := rdr.(io.ReaderAt)
+		if !ok {
+			seeker, err := toreader.NewSeekerAt(rdr)
+			if err != nil {
+				return nil, err
+			}
+			return NewReaderWithOptions(seeker, op)
+		}
+		start, err := rdr.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		if start != 0 {
+			at = io.NewSectionReader(at, start, math.MaxInt64-start)
+		}
+		return NewReaderWithOptions(at, op)
+	case io.ReaderAt:
+		return NewReaderWithOptions(rdr, op)
+	}
+	rdr, err := toreader.NewReaderAt(r, op.MemoryThreshold, op.TempDir)
 	if err != nil {
 		return nil, err
 	}
-	return NewReader(rdr)
+	out, err := NewReaderWithOptions(rdr, op)
+	if err != nil {
+		rdr.Close()
+		return nil, err
+	}
+	out.closer = rdr
+	return out, nil
 }
 
+//NewReader creates a Reader with the default ReaderOptions. The archive is read starting at offset 0 of r. See NewReaderWithOptions.
 func NewReader(r io.ReaderAt) (*Reader, error) {
+	return NewReaderWithOptions(r, DefaultReaderOptions())
+}
+
+//NewReaderWithOptions creates a Reader with the given ReaderOptions.
+//The archive is read starting at offset 0 of r. For an archive that starts elsewhere, such as one appended to another file, use an io.SectionReader.
+func NewReaderWithOptions(r io.ReaderAt, op ReaderOptions) (*Reader, error) {
+	squash, err := openArchive(r, op)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range squash.tables() {
+		if t.present {
+			err = t.init()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	err = squash.initRoot()
+	if err != nil {
+		return nil, err
+	}
+	return squash, nil
+}
+
+//openArchive reads the superblock and compression options, but none of the tables.
+func openArchive(r io.ReaderAt, op ReaderOptions) (*Reader, error) {
 	var squash Reader
 	squash.r = r
-	err := binary.Read(toreader.NewReader(r, 0), binary.LittleEndian, &squash.s)
+	squash.cache = metadata.NewCache(op.MetadataCacheSize)
+	var err error
+	squash.s, err = readSuperblock(r)
 	if err != nil {
 		return nil, err
 	}
-	if !squash.s.checkMagic() {
-		return nil, ErrorMagic
-	}
-	if !squash.s.checkBlockLog() {
-		return nil, ErrorLog
-	}
-	if !squash.s.checkVersion() {
-		return nil, ErrorVersion
-	}
-	switch squash.s.CompType {
-	case GZipCompression:
-		squash.d = decompress.GZip{}
-	case LZMACompression:
-		squash.d = decompress.Lzma{}
-	case LZOCompression:
-		squash.d = decompress.Lzo{}
-	case XZCompression:
-		squash.d = decompress.Xz{}
-	case LZ4Compression:
-		squash.d = decompress.Lz4{}
-	case ZSTDCompression:
-		squash.d = &decompress.Zstd{}
-	default:
-		return nil, errors.New("uh, I need to do this, OR something if very wrong")
-	}
-	if !squash.s.noFragments() && squash.s.FragCount > 0 {
-		fragOffsets := make([]uint64, int(math.Ceil(float64(squash.s.FragCount)/512)))
-		err = binary.Read(toreader.NewReader(r, int64(squash.s.FragTableStart)), binary.LittleEndian, &fragOffsets)
+	//Table sizes are checked against the archive's size, so make sure the archive is actually that large.
+	if squash.s.Size < 96 || squash.s.Size > math.MaxInt64 {
+		return nil, ErrorTruncated
+	}
+	n, err := r.ReadAt(make([]byte, 1), int64(squash.s.Size)-1)
+	if n != 1 {
+		return nil, ErrorTruncated
+	}
+	if squash.s.compressionOptions() {
+		squash.compOptions, err = readCompressionOptions(r, squash.s.CompType)
 		if err != nil {
 			return nil, err
 		}
-		squash.fragEntries = make([]fragEntry, squash.s.FragCount)
-		if len(fragOffsets) == 1 {
-			rdr := metadata.NewReader(toreader.NewReader(r, int64(fragOffsets[0])), squash.d)
-			err = binary.Read(rdr, binary.LittleEndian, &squash.fragEntries)
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			toRead := squash.s.IdCount
-			var curRead uint16
-			var tmp []fragEntry
-			var rdr *metadata.Reader
-			var offset int
-			for i := range fragOffsets {
-				curRead = uint16(math.Min(512, float64(toRead)))
-				tmp = make([]fragEntry, curRead)
-				rdr = metadata.NewReader(toreader.NewReader(r, int64(fragOffsets[i])), squash.d)
-				err = binary.Read(rdr, binary.LittleEndian, &tmp)
-				if err != nil {
-					return nil, err
-				}
-				offset = int(squash.s.IdCount - toRead)
-				for i := range tmp {
-					squash.fragEntries[offset+i] = tmp[i]
-				}
-				toRead -= curRead
-			}
-		}
 	}
-	if squash.s.IdCount > 0 {
-		idOffsets := make([]uint64, int(math.Ceil(float64(squash.s.IdCount)/2048)))
-		err = binary.Read(toreader.NewReader(r, int64(squash.s.IdTableStart)), binary.LittleEndian, &idOffsets)
+	if op.Decompressor != nil {
+		squash.d = op.Decompressor
+	} else {
+		squash.d, err = newDecompressor(squash.s.CompType, squash.compOptions)
 		if err != nil {
 			return nil, err
 		}
-		squash.ids = make([]uint32, squash.s.IdCount)
-		if len(idOffsets) == 1 {
-			rdr := metadata.NewReader(toreader.NewReader(r, int64(idOffsets[0])), squash.d)
-			err = binary.Read(rdr, binary.LittleEndian, &squash.ids)
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			toRead := squash.s.IdCount
-			var curRead uint16
-			var tmp []uint32
-			var rdr *metadata.Reader
-			var offset int
-			for i := range idOffsets {
-				curRead = uint16(math.Min(2048, float64(toRead)))
-				tmp = make([]uint32, curRead)
-				rdr = metadata.NewReader(toreader.NewReader(r, int64(idOffsets[i])), squash.d)
-				err = binary.Read(rdr, binary.LittleEndian, &tmp)
-				if err != nil {
-					return nil, err
-				}
-				offset = int(squash.s.IdCount - toRead)
-				for i := range tmp {
-					squash.ids[offset+i] = tmp[i]
-				}
-				toRead -= curRead
-			}
+	}
+	return &squash, nil
+}
+
+//table is one of the tables read when creating a Reader.
+type table struct {
+	name    string
+	start   uint64
+	present bool
+	init    func() error
+}
+
+//tables returns the tables that are read when creating a Reader, in the order they're read.
+func (r *Reader) tables() []table {
+	return []table{
+		{"fragment", r.s.FragTableStart, !r.s.noFragments() && r.s.FragCount > 0, r.initFragments},
+		{"id", r.s.IdTableStart, r.s.IdCount > 0, r.initIds},
+		{"xattr", r.s.XattrTableStart, !r.s.noXattrs() && r.s.XattrTableStart != math.MaxUint64, r.initXattr},
+	}
+}
+
+func (r *Reader) initFragments() error {
+	if !r.s.fits(uint64(r.s.FragCount), 16) {
+		return ErrorTable
+	}
+	fragOffsets := make([]uint64, int(math.Ceil(float64(r.s.FragCount)/512)))
+	err := binary.Read(toreader.NewReader(r.r, int64(r.s.FragTableStart)), binary.LittleEndian, &fragOffsets)
+	if err != nil {
+		return err
+	}
+	r.fragEntries = make([]fragEntry, r.s.FragCount)
+	if len(fragOffsets) == 1 {
+		rdr := metadata.NewReader(r.r, fragOffsets[0], 0, r.d, nil)
+		return binary.Read(rdr, binary.LittleEndian, &r.fragEntries)
+	}
+	toRead := r.s.FragCount
+	var curRead uint32
+	var tmp []fragEntry
+	var rdr *metadata.Reader
+	var offset int
+	for i := range fragOffsets {
+		curRead = uint32(math.Min(512, float64(toRead)))
+		tmp = make([]fragEntry, curRead)
+		rdr = metadata.NewReader(r.r, fragOffsets[i], 0, r.d, nil)
+		err = binary.Read(rdr, binary.LittleEndian, &tmp)
+		if err != nil {
+			return err
 		}
+		offset = int(r.s.FragCount - toRead)
+		for i := range tmp {
+			r.fragEntries[offset+i] = tmp[i]
+		}
+		toRead -= curRead
 	}
-	root, err := squash.inodeFromRef(squash.s.RootInodeRef)
+	return nil
+}
+
+func (r *Reader) initIds() error {
+	if !r.s.fits(uint64(r.s.IdCount), 4) {
+		return ErrorTable
+	}
+	idOffsets := make([]uint64, int(math.Ceil(float64(r.s.IdCount)/2048)))
+	err := binary.Read(toreader.NewReader(r.r, int64(r.s.IdTableStart)), binary.LittleEndian, &idOffsets)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	rootEnts, err := squash.readDirectory(root)
+	r.ids = make([]uint32, r.s.IdCount)
+	if len(idOffsets) == 1 {
+		rdr := metadata.NewReader(r.r, idOffsets[0], 0, r.d, nil)
+		return binary.Read(rdr, binary.LittleEndian, &r.ids)
+	}
+	toRead := r.s.IdCount
+	var curRead uint16
+	var tmp []uint32
+	var rdr *metadata.Reader
+	var offset int
+	for i := range idOffsets {
+		curRead = uint16(math.Min(2048, float64(toRead)))
+		tmp = make([]uint32, curRead)
+		rdr = metadata.NewReader(r.r, idOffsets[i], 0, r.d, nil)
+		err = binary.Read(rdr, binary.LittleEndian, &tmp)
+		if err != nil {
+			return err
+		}
+		offset = int(r.s.IdCount - toRead)
+		for i := range tmp {
+			r.ids[offset+i] = tmp[i]
+		}
+		toRead -= curRead
+	}
+	return nil
+}
+
+//initRoot reads the root directory and sets up FS.
+func (r *Reader) initRoot() error {
+	root, err := r.inodeFromRef(r.s.RootInodeRef)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	rootEnts, err := r.readDirectory(root)
+	if err != nil {
+		return err
 	}
 	enType := root.Type
 	if enType == inode.EDir {
 		enType = inode.Dir
 	}
-	squash.FS = &FS{
+	r.FS = &FS{
 		e: rootEnts,
 		File: &File{
-			rdr: &squash,
-			i:   root,
+			i: root,
 			e: directory.Entry{
 				Name: "",
 				Type: enType,
 			},
-			r: &squash,
+			r: r,
 		},
 	}
-	return &squash, nil
+	return nil
 }
 
 func (r *Reader) initExport() (err error) {
+	if !r.s.fits(uint64(r.s.InodeCount), 8) {
+		return ErrorTable
+	}
 	num := int(math.Ceil(float64(r.s.InodeCount) / 1024))
 	offsets := make([]uint64, num)
 	err = binary.Read(toreader.NewReader(r.r, int64(r.s.ExportTableStart)), binary.LittleEndian, &offsets)
@@ -188,7 +327,7 @@ func (r *Reader) initExport() (err error) {
 	var new []uint64
 	var rdr *metadata.Reader
 	for i := range offsets {
-		rdr = metadata.NewReader(toreader.NewReader(r.r, int64(offsets[i])), r.d)
+		rdr = metadata.NewReader(r.r, offsets[i], 0, r.d, nil)
 		toRead = uint32(math.Min(1024, float64(left)))
 		new = make([]uint64, toRead)
 		err = binary.Read(rdr, binary.LittleEndian, &new)
@@ -209,12 +348,37 @@ func (r *Reader) inode(index uint32) (i inode.Inode, err error) {
 				return
 			}
 		}
+		if index == 0 || int(index) > len(r.exportTable) {
+			err = errors.New("inode number out of range")
+			return
+		}
 		return r.inodeFromRef(r.exportTable[index-1])
 	}
 	err = errors.New("archive is not exportable")
 	return
 }
 
+//Info returns the information from the archive's superblock.
+func (r Reader) Info() Info {
+	return newInfo(r.s, r.compOptions)
+}
+
+//CompressionOptions returns the compression options stored in the archive. If there are none, returns nil.
+func (r Reader) CompressionOptions() CompressionOptions {
+	return r.compOptions
+}
+
 func (r Reader) ModTime() time.Time {
 	return time.Unix(int64(r.s.ModTime), 0)
 }
+
+//Close releases anything held by the Reader, such as the temporary file used by NewReaderFromReader.
+//The io.ReaderAt given to NewReader is not closed.
+func (r *Reader) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+	err := r.closer.Close()
+	r.closer = nil
+	return err
+}
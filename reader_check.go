@@ -0,0 +1,298 @@
+package squashfs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"path"
+
+	"github.com/CalebQ42/squashfs/internal/directory"
+	"github.com/CalebQ42/squashfs/internal/inode"
+	"github.com/CalebQ42/squashfs/internal/metadata"
+)
+
+//Finding is a problem found by Reader.Check.
+type Finding struct {
+	//Path is the path of the file the problem is with. Empty if the problem isn't with a file.
+	Path string
+	//Problem describes what's wrong.
+	Problem string
+	//Offset is the location in the archive of the problem.
+	//For inodes and directories, it's the location of the metadata block they're in.
+	Offset uint64
+}
+
+func (f Finding) String() string {
+	if f.Path == "" {
+		return fmt.Sprintf("offset %d: %s", f.Offset, f.Problem)
+	}
+	return fmt.Sprintf("offset %d: %s: %s", f.Offset, f.Path, f.Problem)
+}
+
+type checker struct {
+	ctx      context.Context
+	r        Reader
+	seen     map[uint32]bool
+	fragLens []int64
+	findings []Finding
+}
+
+func (c *checker) add(p string, offset uint64, format string, a ...any) {
+	c.findings = append(c.findings, Finding{
+		Path:    p,
+		Problem: fmt.Sprintf(format, a...),
+		Offset:  offset,
+	})
+}
+
+//Check validates the archive by walking every reachable inode and directory, decompressing every data block and fragment,
+//and resolving every entry in the export table.
+//Problems are returned as Findings instead of stopping the check. An error is only returned if ctx is done.
+func (r Reader) Check(ctx context.Context) ([]Finding, error) {
+	c := &checker{
+		ctx:  ctx,
+		r:    r,
+		seen: make(map[uint32]bool),
+	}
+	c.checkTables()
+	c.checkFragments()
+	offset := r.s.InodeTableStart + r.s.RootInodeRef>>16
+	root, err := r.inodeFromRef(r.s.RootInodeRef)
+	if err != nil {
+		c.add("", offset, "can't read root inode: %v", err)
+		return c.findings, nil
+	}
+	if root.Type != inode.Dir && root.Type != inode.EDir {
+		c.add("", offset, "root inode is type %d, not a directory", root.Type)
+		return c.findings, nil
+	}
+	err = c.checkInode(".", offset, root)
+	if err != nil {
+		return c.findings, err
+	}
+	if uint32(len(c.seen)) != r.s.InodeCount {
+		c.add("", 0, "found %d inodes, but the superblock has %d", len(c.seen), r.s.InodeCount)
+	}
+	return c.findings, c.checkExport()
+}
+
+//CheckArchive checks the archive in r, starting at offset 0, the same as Reader.Check, without needing NewReader to succeed first.
+//Problems with the fragment, id, and xattr tables, which make NewReader fail, are returned as Findings.
+//An error is only returned if the superblock or compression options can't be read, or if ctx is done.
+func CheckArchive(ctx context.Context, r io.ReaderAt, op ReaderOptions) ([]Finding, error) {
+	squash, err := openArchive(r, op)
+	if err != nil {
+		return nil, err
+	}
+	var findings []Finding
+	for _, t := range squash.tables() {
+		if t.present {
+			err = t.init()
+			if err != nil {
+				findings = append(findings, Finding{
+					Problem: fmt.Sprintf("can't read %s table: %v", t.name, err),
+					Offset:  t.start,
+				})
+			}
+		}
+	}
+	more, err := squash.Check(ctx)
+	return append(findings, more...), err
+}
+
+//checkExport makes sure every entry in the export table refers to the inode with that number.
+func (c *checker) checkExport() error {
+	if !c.r.s.exportable() {
+		return nil
+	}
+	err := c.r.initExport()
+	if err != nil {
+		c.add("", c.r.s.ExportTableStart, "can't read export table: %v", err)
+		return nil
+	}
+	for i, ref := range c.r.exportTable {
+		if err = c.ctx.Err(); err != nil {
+			return err
+		}
+		offset := c.r.s.InodeTableStart + ref>>16
+		in, err := c.r.inodeFromRef(ref)
+		if err != nil {
+			c.add("", offset, "can't read inode %d from the export table: %v", i+1, err)
+		} else if in.Num != uint32(i+1) {
+			c.add("", offset, "export table entry %d refers to inode %d", i+1, in.Num)
+		}
+	}
+	return nil
+}
+
+//checkTables makes sure every table starts inside the archive.
+func (c *checker) checkTables() {
+	s := c.r.s
+	tables := []struct {
+		name    string
+		start   uint64
+		present bool
+	}{
+		{"inode", s.InodeTableStart, true},
+		{"directory", s.DirTableStart, true},
+		{"fragment", s.FragTableStart, !s.noFragments() && s.FragCount > 0},
+		{"export", s.ExportTableStart, s.exportable()},
+		{"id", s.IdTableStart, true},
+		{"xattr", s.XattrTableStart, !s.noXattrs() && s.XattrTableStart != math.MaxUint64},
+	}
+	for _, t := range tables {
+		if t.present && t.start >= s.Size {
+			c.add("", t.start, "%s table starts past the end of the archive (%d bytes)", t.name, s.Size)
+		}
+	}
+}
+
+//checkFragments decompresses every fragment block and records their sizes. Bad fragment blocks have a size of -1.
+func (c *checker) checkFragments() {
+	c.fragLens = make([]int64, len(c.r.fragEntries))
+	for i, f := range c.r.fragEntries {
+		c.fragLens[i] = -1
+		dat, ok := c.checkBlock("", f.Start, f.Size, fmt.Sprintf("fragment block %d", i))
+		if ok {
+			c.fragLens[i] = int64(len(dat))
+		}
+	}
+}
+
+//checkBlock reads and decompresses a data or fragment block. If there's a problem, it's added as a finding and ok is false.
+func (c *checker) checkBlock(p string, start uint64, size uint32, name string) (dat []byte, ok bool) {
+	realSize := size &^ (1 << 24)
+	if realSize > c.r.s.BlockSize {
+		c.add(p, start, "%s is %d bytes, larger then the block size", name, realSize)
+		return nil, false
+	}
+	if start+uint64(realSize) > c.r.s.Size {
+		c.add(p, start, "%s goes past the end of the archive", name)
+		return nil, false
+	}
+	dat = make([]byte, realSize)
+	_, err := c.r.r.ReadAt(dat, int64(start))
+	if err != nil {
+		c.add(p, start, "can't read %s: %v", name, err)
+		return nil, false
+	}
+	if realSize == size {
+		var rdr io.ReadCloser
+		rdr, err = c.r.d.Reader(bytes.NewReader(dat))
+		if err == nil {
+			//Read one byte past the block size to catch blocks that are too large without reading all of them.
+			dat, err = io.ReadAll(io.LimitReader(rdr, int64(c.r.s.BlockSize)+1))
+			rdr.Close()
+		}
+		if err != nil {
+			c.add(p, start, "can't decompress %s: %v", name, err)
+			return nil, false
+		}
+	}
+	if uint32(len(dat)) > c.r.s.BlockSize {
+		c.add(p, start, "%s decompresses to more then the block size", name)
+		return nil, false
+	}
+	return dat, true
+}
+
+//checkInode checks an inode and, if it's a directory, all of it's children. offset is the location of the inode's metadata block.
+func (c *checker) checkInode(p string, offset uint64, i inode.Inode) error {
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
+	if c.seen[i.Num] {
+		return nil
+	}
+	c.seen[i.Num] = true
+	if i.Num == 0 || i.Num > c.r.s.InodeCount {
+		c.add(p, offset, "inode number %d is out of range", i.Num)
+	}
+	if i.UidInd >= c.r.s.IdCount {
+		c.add(p, offset, "uid index %d is out of range (%d ids)", i.UidInd, c.r.s.IdCount)
+	}
+	if i.GidInd >= c.r.s.IdCount {
+		c.add(p, offset, "gid index %d is out of range (%d ids)", i.GidInd, c.r.s.IdCount)
+	}
+	if ind := xattrInd(i); ind != 0xFFFFFFFF && int(ind) >= len(c.r.xattrIDs) {
+		c.add(p, offset, "xattr index %d is out of range (%d xattr ids)", ind, len(c.r.xattrIDs))
+	}
+	switch d := i.Data.(type) {
+	case inode.File:
+		c.checkFile(p, uint64(d.BlockStart), d.BlockSizes, d.FragInd, d.Offset, uint64(d.Size))
+	case inode.EFile:
+		c.checkFile(p, d.BlockStart, d.BlockSizes, d.FragInd, d.Offset, d.Size)
+	case inode.Directory:
+		return c.checkDir(p, d.BlockStart, d.Offset, uint32(d.Size))
+	case inode.EDirectory:
+		return c.checkDir(p, d.BlockStart, d.Offset, d.Size)
+	}
+	return nil
+}
+
+func (c *checker) checkFile(p string, start uint64, sizes []uint32, fragInd, fragOffset uint32, size uint64) {
+	blockSize := uint64(c.r.s.BlockSize)
+	for i, s := range sizes {
+		if s&^(1<<24) == 0 {
+			//Hole
+			continue
+		}
+		dat, ok := c.checkBlock(p, start, s, fmt.Sprintf("data block %d", i))
+		start += uint64(s &^ (1 << 24))
+		if !ok {
+			continue
+		}
+		want := blockSize
+		if fragInd == 0xFFFFFFFF && i == len(sizes)-1 && size%blockSize != 0 {
+			want = size % blockSize
+		}
+		if uint64(len(dat)) != want {
+			c.add(p, start-uint64(s&^(1<<24)), "data block %d decompresses to %d bytes, want %d", i, len(dat), want)
+		}
+	}
+	if fragInd == 0xFFFFFFFF {
+		return
+	}
+	if fragInd >= c.r.s.FragCount || int(fragInd) >= len(c.fragLens) {
+		c.add(p, start, "fragment index %d is out of range (%d fragments)", fragInd, c.r.s.FragCount)
+		return
+	}
+	if fragLen := c.fragLens[fragInd]; fragLen >= 0 && int64(fragOffset)+int64(size%blockSize) > fragLen {
+		c.add(p, c.r.fragEntries[fragInd].Start, "fragment at offset %d of length %d goes past the end of fragment block %d", fragOffset, size%blockSize, fragInd)
+	}
+}
+
+func (c *checker) checkDir(p string, blockStart uint32, blockOffset uint16, size uint32) error {
+	offset := c.r.s.DirTableStart + uint64(blockStart)
+	rdr := metadata.NewReader(c.r.r, offset, blockOffset, c.r.d, c.r.cache)
+	ents, problems, err := directory.Check(rdr, size)
+	for _, prob := range problems {
+		c.add(p, offset, "%s", prob)
+	}
+	if err != nil {
+		c.add(p, offset, "can't read directory: %v", err)
+	}
+	for _, e := range ents {
+		childPath := path.Join(p, e.Name)
+		childOffset := c.r.s.InodeTableStart + uint64(e.BlockStart)
+		i, err := c.r.inodeFromDir(e)
+		if err != nil {
+			c.add(childPath, childOffset, "can't read inode: %v", err)
+			continue
+		}
+		if i.Num != e.Num {
+			c.add(childPath, childOffset, "directory entry has inode number %d, but the inode has %d", e.Num, i.Num)
+		}
+		if typ := i.Type; typ != e.Type && typ != e.Type+inode.EDir-inode.Dir {
+			c.add(childPath, childOffset, "directory entry has type %d, but the inode has type %d", e.Type, typ)
+			continue
+		}
+		err = c.checkInode(childPath, childOffset, i)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
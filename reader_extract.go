@@ -0,0 +1,463 @@
+package squashfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/CalebQ42/squashfs/internal/inode"
+)
+
+//ExtractionProgress is given to ExtractionOptions.Progress when a file is started, as it's data is written, and when it's finished.
+type ExtractionProgress struct {
+	Err          error  //If Done, why the file failed to extract. nil if it succeeded.
+	Path         string //The file's path, relative to the extraction folder
+	Size         int64  //The file's size. 0 if it's not a regular file.
+	Written      int64  //How much of the file's data has been written
+	TotalSize    int64  //The expected size of everything being extracted, from the inodes' sizes
+	TotalWritten int64  //How much data has been written so far for everything being extracted
+	Done         bool   //If the file is finished
+}
+
+//ExtractionSummary describes a finished extraction.
+type ExtractionSummary struct {
+	Skipped  []string            //Paths that weren't extracted, such as devices when using DeviceSkip
+	Warnings []string            //Problems that didn't stop the extraction, such as hard links that were copied instead
+	Bytes    int64               //The size of all file data written, including holes
+	Files    int                 //The number of files, directories, symlinks, and other entries created
+	Types    map[fs.FileMode]int //How many of Files are of each type, by their fs.FileMode type bits. Regular files are 0. Hard links are only counted in Links.
+	Links    int                 //How many of Files are hard links to an inode that was already extracted
+	Duration time.Duration       //How long the extraction took
+}
+
+//extractState is shared by everything extracted with a single call to ExtractWithOptions.
+type extractState struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	err      error
+	links    map[uint32]*hardLink
+	paths    map[string]uint32 //Every path extracted to, and the inode that was extracted there
+	progress func(ExtractionProgress)
+	root     string
+	realRoot string   //root, absolute and with symlinks resolved
+	rootDir  *os.File //root, opened for openat2 on Linux
+	workers  chan struct{}
+	filter   *extractFilter
+	summary  ExtractionSummary
+	total    int64
+	written  int64
+	errMut   sync.Mutex
+	linksMut sync.Mutex
+	sumMut   sync.Mutex
+}
+
+func newExtractState(ctx context.Context, root string, progress func(ExtractionProgress), workers int) *extractState {
+	ctx, cancel := context.WithCancel(ctx)
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	return &extractState{
+		ctx:      ctx,
+		cancel:   cancel,
+		links:    make(map[uint32]*hardLink),
+		paths:    make(map[string]uint32),
+		progress: progress,
+		root:     filepath.Clean(root),
+		workers:  make(chan struct{}, workers),
+		summary: ExtractionSummary{
+			Types: make(map[fs.FileMode]int),
+		},
+	}
+}
+
+//acquire takes a worker, if one's free, for a new goroutine. release must be called once the goroutine is done.
+//Every goroutine that's extracting holds a worker, including the one that called ExtractWithOptions.
+//Since goroutines are only started when a worker is free, and idle is used when waiting on other goroutines, there's never a deadlock.
+func (s *extractState) acquire() bool {
+	select {
+	case s.workers <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *extractState) release() {
+	<-s.workers
+}
+
+//idle gives up the current goroutine's worker while calling wait, then takes it back.
+func (s *extractState) idle(wait func()) {
+	s.release()
+	wait()
+	s.workers <- struct{}{}
+}
+
+//extractFile is a file that's being extracted.
+type extractFile struct {
+	path    string
+	typ     fs.FileMode
+	size    int64
+	written int64
+	skipped bool
+	link    bool
+}
+
+//rel returns path relative to the extraction folder.
+func (s *extractState) rel(path string) string {
+	rel, err := filepath.Rel(s.root, path)
+	if err != nil {
+		return path
+	}
+	return filepath.ToSlash(rel)
+}
+
+//report calls the progress function. sumMut must be held.
+func (s *extractState) report(f *extractFile, done bool, err error) {
+	if s.progress == nil {
+		return
+	}
+	s.progress(ExtractionProgress{
+		Err:          err,
+		Path:         s.rel(f.path),
+		Size:         f.size,
+		Written:      f.written,
+		TotalSize:    s.total,
+		TotalWritten: s.written,
+		Done:         done,
+	})
+}
+
+//start reports that the file at path, of the given fs.FileMode type, has started being extracted. finish must be called once it's done.
+func (s *extractState) start(path string, typ fs.FileMode, size int64) *extractFile {
+	f := &extractFile{
+		path: path,
+		typ:  typ,
+		size: size,
+	}
+	s.sumMut.Lock()
+	defer s.sumMut.Unlock()
+	s.report(f, false, nil)
+	return f
+}
+
+//wrote reports n more bytes of f have been written.
+func (s *extractState) wrote(f *extractFile, n int64) {
+	s.sumMut.Lock()
+	defer s.sumMut.Unlock()
+	f.written += n
+	s.written += n
+	s.report(f, false, nil)
+}
+
+//finish reports that f is done, and adds it to the summary if it was created.
+func (s *extractState) finish(f *extractFile, err *error) {
+	s.sumMut.Lock()
+	defer s.sumMut.Unlock()
+	if *err == nil && !f.skipped {
+		s.summary.Files++
+		if f.link {
+			s.summary.Links++
+		} else {
+			s.summary.Types[f.typ]++
+		}
+		s.summary.Bytes += f.written
+	}
+	s.report(f, true, *err)
+}
+
+//skip marks f as skipped.
+func (s *extractState) skip(f *extractFile) {
+	s.sumMut.Lock()
+	defer s.sumMut.Unlock()
+	f.skipped = true
+	s.summary.Skipped = append(s.summary.Skipped, s.rel(f.path))
+}
+
+//warn adds a warning about the file at path to the summary.
+func (s *extractState) warn(path, warning string) {
+	s.sumMut.Lock()
+	defer s.sumMut.Unlock()
+	s.summary.Warnings = append(s.summary.Warnings, s.rel(path)+": "+warning)
+}
+
+//created adds a directory to the summary.
+func (s *extractState) created() {
+	s.sumMut.Lock()
+	defer s.sumMut.Unlock()
+	s.summary.Files++
+	s.summary.Types[fs.ModeDir]++
+}
+
+//progressWriter reports everything written, or skipped over, to the extractState.
+type progressWriter struct {
+	*os.File
+	s *extractState
+	f *extractFile
+}
+
+func (p progressWriter) Write(b []byte) (int, error) {
+	n, err := p.File.Write(b)
+	p.s.wrote(p.f, int64(n))
+	return n, err
+}
+
+func (p progressWriter) Seek(offset int64, whence int) (int64, error) {
+	n, err := p.File.Seek(offset, whence)
+	if err == nil && whence == io.SeekCurrent {
+		p.s.wrote(p.f, offset)
+	}
+	return n, err
+}
+
+//extractSize returns the total size of the regular files that will be extracted. Hard links are only counted once, unless they're copied.
+func (f File) extractSize(op ExtractionOptions) (int64, error) {
+	if !f.IsDir() {
+		return fileSize(f.i), nil
+	}
+	seen := make(map[uint32]bool)
+	var size func(i inode.Inode, dir string, included bool) (int64, error)
+	size = func(i inode.Inode, dir string, included bool) (int64, error) {
+		ents, err := f.r.readDirectory(i)
+		if err != nil {
+			return 0, err
+		}
+		var total int64
+		for _, e := range ents {
+			if e.Type != inode.Dir && e.Type != inode.Fil {
+				continue
+			}
+			name := path.Join(dir, e.Name)
+			keep, all, err := op.state.filter.keep(f.r, name, e, included)
+			if err != nil {
+				return 0, err
+			} else if !keep || (e.Type == inode.Fil && op.rewrites() && op.destPath(name) == "") {
+				continue
+			}
+			child, err := f.r.inodeFromDir(e)
+			if err != nil {
+				return 0, err
+			}
+			if e.Type == inode.Dir {
+				var sub int64
+				sub, err = size(child, name, all)
+				total += sub
+			} else if op.CopyHardLinks || linkCount(child) < 2 || !seen[child.Num] {
+				seen[child.Num] = true
+				total += fileSize(child)
+			}
+			if err != nil {
+				return 0, err
+			}
+		}
+		return total, nil
+	}
+	return size(f.i, "", op.included)
+}
+
+//fail records the first error of the extraction and stops everything else being extracted.
+func (s *extractState) fail(err error) {
+	s.errMut.Lock()
+	defer s.errMut.Unlock()
+	if s.err == nil {
+		s.err = err
+		s.cancel()
+	}
+}
+
+//claim records that inode num is being extracted to path. Returns false if it's already been extracted there,
+//or is being extracted there, during this extraction.
+func (s *extractState) claim(path string, num uint32) bool {
+	s.linksMut.Lock()
+	defer s.linksMut.Unlock()
+	if prev, ok := s.paths[path]; ok && prev == num {
+		return false
+	}
+	s.paths[path] = num
+	return true
+}
+
+//hardLink is the first extracted path of an inode with multiple links.
+type hardLink struct {
+	err   error
+	done  chan struct{}
+	first string //Where the inode is first extracted to
+	path  string //Where the inode was created, after OnConflict. "" if it was skipped.
+}
+
+//hardLink returns the hardLink for inode num. If num hasn't been seen before, first is true and path is recorded as where it's first extracted to.
+//If first is true, the hardLink's path must be set, and finish called, once the inode is extracted.
+func (s *extractState) hardLink(num uint32, path string) (l *hardLink, first bool) {
+	s.linksMut.Lock()
+	defer s.linksMut.Unlock()
+	if l = s.links[num]; l != nil {
+		return l, false
+	}
+	l = &hardLink{
+		first: path,
+		done:  make(chan struct{}),
+	}
+	s.links[num] = l
+	return l, true
+}
+
+func (l *hardLink) finish(err *error) {
+	l.err = *err
+	close(l.done)
+}
+
+//extractHardLink links the File, in d, to the first location it's inode was extracted to. OnConflict is applied to the link.
+//If the first extraction failed or was skipped, the File is extracted as a copy instead.
+func (f File) extractHardLink(l *hardLink, d *destDir, op ExtractionOptions) (err error) {
+	op.state.idle(func() { <-l.done })
+	var old *destDir
+	if l.err == nil && l.path != "" {
+		old, err = op.state.openDir(filepath.Dir(l.path))
+		if err == nil {
+			defer old.close()
+			_, _, err = old.lstat(filepath.Base(l.path))
+		}
+	}
+	if l.err != nil || l.path == "" || err != nil {
+		op.state.warn(d.join(f.e.Name), "couldn't link to "+op.state.rel(l.first)+", extracted a copy instead")
+		op.CopyHardLinks = true
+		_, err = f.extractEntry(d, op)
+		return err
+	}
+	var c conflict
+	f.e.Name, c, err = f.conflict(d, f.e.Name, op)
+	path := d.join(f.e.Name)
+	if err != nil {
+		return err
+	} else if c == conflictSkip {
+		if op.Verbose {
+			log.Println("Skipping", path, "since it already exists")
+		}
+		op.state.skip(&extractFile{path: path})
+		return nil
+	}
+	fil := op.state.start(path, typeMode(f.i.Type), 0)
+	fil.link = true
+	defer op.state.finish(fil, &err)
+	err = d.link(old, filepath.Base(l.path), f.e.Name)
+	if err != nil {
+		if op.Verbose {
+			log.Println("Error while linking", path, "to", l.path)
+		}
+		return err
+	}
+	return nil
+}
+
+//conflict is how something already at a path is dealt with.
+type conflict uint8
+
+const (
+	conflictNone  = conflict(iota) //Nothing is there, or it was removed
+	conflictSkip                   //Leave it alone and don't extract the File
+	conflictKeep                   //It's a directory that's extracted into, but keeps it's attributes
+	conflictMerge                  //It's a directory that's extracted into, and given the File's attributes
+)
+
+//conflict checks for something at name, in d, and deals with it according to op.OnConflict.
+//Returns the name the File should be extracted as, which is only different from name with ConflictRename.
+func (f File) conflict(d *destDir, name string, op ExtractionOptions) (string, conflict, error) {
+	isDir, modTime, err := d.lstat(name)
+	if os.IsNotExist(err) {
+		return name, conflictNone, nil
+	} else if err != nil {
+		return name, conflictNone, err
+	}
+	skip := conflictSkip
+	if f.IsDir() && isDir {
+		skip = conflictKeep
+	}
+	switch op.OnConflict {
+	case ConflictSkip:
+		return name, skip, nil
+	case ConflictError:
+		return name, conflictNone, &fs.PathError{
+			Op:   "extract",
+			Path: d.join(name),
+			Err:  fs.ErrExist,
+		}
+	case ConflictNewer:
+		if !time.Unix(int64(f.i.ModTime), 0).After(modTime) {
+			return name, skip, nil
+		}
+	case ConflictRename:
+		for i := 1; ; i++ {
+			renamed := name + "." + strconv.Itoa(i)
+			_, _, err = d.lstat(renamed)
+			if os.IsNotExist(err) {
+				return renamed, conflictNone, nil
+			} else if err != nil {
+				return name, conflictNone, err
+			}
+		}
+	}
+	if f.IsDir() && isDir {
+		return name, conflictMerge, nil
+	}
+	err = d.remove(name)
+	//Directories are only removed if they're empty.
+	if isDir && (errors.Is(err, syscall.ENOTEMPTY) || errors.Is(err, syscall.EEXIST)) {
+		err = &fs.PathError{
+			Op:   "extract",
+			Path: d.join(name),
+			Err:  ErrNotEmpty,
+		}
+	}
+	return name, conflictNone, err
+}
+
+//restore applies the File's ownership, permissions, and modification time to name, in d, depending on op.
+//Ownership is applied first, since changing it can clear setuid and setgid.
+func (f File) restore(d *destDir, name string, op ExtractionOptions) error {
+	path := d.join(name)
+	if op.Ownership == OwnershipAlways || (op.Ownership == OwnershipAuto && os.Geteuid() == 0) {
+		if int(f.i.UidInd) >= len(f.r.ids) || int(f.i.GidInd) >= len(f.r.ids) {
+			return errors.New("uid or gid index out of range")
+		}
+		err := d.lchown(name, int(f.r.ids[f.i.UidInd]), int(f.r.ids[f.i.GidInd]))
+		if err != nil {
+			if op.Verbose {
+				log.Println("Error while setting the owner of", path)
+			}
+			return err
+		}
+	}
+	//Symlinks don't have permissions of their own, and their modification time is left as is.
+	if f.IsSymlink() {
+		return nil
+	}
+	if !op.IgnorePerm {
+		err := d.chmod(name, f.i.Perm)
+		if err != nil {
+			if op.Verbose {
+				log.Println("Error while setting the permissions of", path)
+			}
+			return err
+		}
+	}
+	if !op.IgnoreModTime {
+		err := d.chtimes(name, time.Unix(int64(f.i.ModTime), 0))
+		if err != nil {
+			if op.Verbose {
+				log.Println("Error while setting the modification time of", path)
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package squashfs
 
 import (
+	"context"
 	"errors"
 	"io"
 	"io/fs"
@@ -9,7 +10,9 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/CalebQ42/squashfs/internal/data"
 	"github.com/CalebQ42/squashfs/internal/directory"
 	"github.com/CalebQ42/squashfs/internal/inode"
 )
@@ -17,8 +20,8 @@ import (
 //File represents a file inside a squashfs archive.
 type File struct {
 	i        inode.Inode
-	rdr      io.Reader
-	fullRdr  io.WriterTo
+	rdr      *data.Reader
+	fullRdr  *data.FullReader
 	r        *Reader
 	parent   *FS
 	e        directory.Entry
@@ -27,6 +30,12 @@ type File struct {
 
 var (
 	ErrReadNotFile = errors.New("read called on non-file")
+	ErrMknod       = errors.New("devices can only be created as root and special files can only be created on linux")
+	//ErrUnsafePath is returned, in a *fs.PathError, when extracting would create something outside of the extraction folder.
+	//This only happens with crafted archives, such as ones with ".." as a file name.
+	ErrUnsafePath = errors.New("path is outside of the extraction folder")
+	//ErrNotEmpty is returned, in a *fs.PathError, when ConflictOverwrite or ConflictNewer would replace a directory that isn't empty with something that isn't a directory.
+	ErrNotEmpty = errors.New("can't replace a directory that isn't empty")
 )
 
 func (r Reader) newFile(en directory.Entry, parent *FS) (*File, error) {
@@ -34,8 +43,11 @@ func (r Reader) newFile(en directory.Entry, parent *FS) (*File, error) {
 	if err != nil {
 		return nil, err
 	}
-	var rdr io.Reader
-	var full io.WriterTo
+	if parent.isAncestor(i) {
+		return nil, ErrorLoop
+	}
+	var rdr *data.Reader
+	var full *data.FullReader
 	if i.Type == inode.Fil || i.Type == inode.EFil {
 		full, rdr, err = r.getReaders(i)
 		if err != nil {
@@ -54,7 +66,12 @@ func (r Reader) newFile(en directory.Entry, parent *FS) (*File, error) {
 
 //Stat returns the File's fs.FileInfo
 func (f File) Stat() (fs.FileInfo, error) {
-	return newFileInfo(f.e, f.i), nil
+	return newFileInfo(f.r, f.e, f.i), nil
+}
+
+//Xattrs returns the File's extended attributes. If the File has none, returns nil.
+func (f File) Xattrs() (map[string][]byte, error) {
+	return f.r.xattrs(f.i)
 }
 
 //Read reads the data from the file. Only works if file is a normal file.
@@ -68,10 +85,38 @@ func (f File) Read(p []byte) (int, error) {
 	return f.rdr.Read(p)
 }
 
+//ReadAt reads len(p) bytes from the file starting at off. Only works if file is a normal file.
+//Only the blocks that contain the range are decompressed. Can be used concurrently and doesn't effect Read.
+func (f File) ReadAt(p []byte, off int64) (int, error) {
+	if !f.IsRegular() {
+		return 0, ErrReadNotFile
+	}
+	return f.fullRdr.ReadAt(p, off)
+}
+
+//Seek sets the offset of the next Read. Only works if file is a normal file.
+func (f File) Seek(offset int64, whence int) (int64, error) {
+	if !f.IsRegular() {
+		return 0, ErrReadNotFile
+	}
+	if f.rdr == nil {
+		return 0, fs.ErrClosed
+	}
+	return f.rdr.Seek(offset, whence)
+}
+
 //WriteTo writes all data from the file to the writer. This is multi-threaded.
 //The underlying reader is seperate from the one used with Read and can be reused.
 func (f File) WriteTo(w io.Writer) (int64, error) {
-	return f.fullRdr.WriteTo(w)
+	return f.WriteToContext(context.Background(), w)
+}
+
+//WriteToContext is WriteTo, but stops decompressing and returns ctx.Err() once ctx is done.
+func (f File) WriteToContext(ctx context.Context, w io.Writer) (int64, error) {
+	if !f.IsRegular() {
+		return 0, ErrReadNotFile
+	}
+	return f.fullRdr.WriteToContext(ctx, w)
 }
 
 //Close simply nils the underlying reader. Here mostly to satisfy fs.File
@@ -93,8 +138,8 @@ func (f *File) ReadDir(n int) (out []fs.DirEntry, err error) {
 	start, end := 0, len(ents)
 	if n > 0 {
 		start, end = f.dirsRead, f.dirsRead+n
-		if end > len(f.r.e) {
-			end = len(f.r.e)
+		if end > len(ents) {
+			end = len(ents)
 			err = io.EOF
 		}
 	}
@@ -136,6 +181,15 @@ func (f File) IsRegular() bool {
 	return f.i.Type == inode.Fil || f.i.Type == inode.EFil
 }
 
+//IsSpecial returns if the File is a block device, char device, fifo, or socket.
+func (f File) IsSpecial() bool {
+	switch f.i.Type {
+	case inode.Block, inode.Char, inode.Fifo, inode.Sock, inode.EBlock, inode.EChar, inode.EFifo, inode.ESock:
+		return true
+	}
+	return false
+}
+
 //IsSymlink yep.
 func (f File) IsSymlink() bool {
 	return f.i.Type == inode.Sym || f.i.Type == inode.ESym
@@ -177,13 +231,98 @@ func (f File) GetSymlinkFile() *File {
 
 //ExtractionOptions are available options on how to extract.
 type ExtractionOptions struct {
-	LogOutput          io.Writer   //Where error log should write. If nil, uses os.Stdout. Has no effect if verbose is false.
-	DereferenceSymlink bool        //Replace symlinks with the target file
-	UnbreakSymlink     bool        //Try to make sure symlinks remain unbroken when extracted, without changing the symlink
-	Verbose            bool        //Prints extra info to log on an error
-	FolderPerm         fs.FileMode //The permissions used when creating the extraction folder
+	LogOutput          io.Writer    //Where error log should write. If nil, uses os.Stdout. Has no effect if verbose is false.
+	DereferenceSymlink bool         //Replace symlinks with the target file
+	UnbreakSymlink     bool         //Try to make sure symlinks remain unbroken when extracted, without changing the symlink
+	Verbose            bool         //Prints extra info to log on an error
+	FolderPerm         fs.FileMode  //The permissions used when creating the extraction folder
+	DevicePolicy       DevicePolicy //What to do with devices, fifos, and sockets that can't be created (such as devices when not root)
+	CopyHardLinks      bool         //Extract each hard link as a separate copy instead of linking them together
+	IgnorePerm         bool         //Don't apply the archive's permissions. Files and folders are created with the default permissions instead.
+	IgnoreModTime      bool         //Don't apply the archive's modification times
+	Ownership          Ownership    //When to apply the archive's uid and gid
+	//Progress, if set, is called when each file starts and finishes extracting, and as it's data is written.
+	//Directories only count towards the summary. Calls are never concurrent, but do block extraction, so it should return quickly.
+	Progress func(ExtractionProgress)
+	//Workers is the most files and data blocks that are extracted at once, across everything being extracted. If <= 0, runtime.NumCPU() is used.
+	Workers int
+	//Include and Exclude limit what's extracted to paths, relative to the File being extracted, that match one of Include's patterns and none of Exclude's.
+	//Patterns are matched per path element with path.Match, except "**" matches any number of elements, such as "usr/lib/**".
+	//If a directory matches Include, everything inside of it is included. Directories that can't contain a match aren't read.
+	//If Include is empty, everything is included.
+	Include []string
+	Exclude []string
+	//Filter, if set, is called for each path that matches the patterns. Returning false skips it, and if it's a directory, everything inside of it.
+	Filter func(path string, info fs.FileInfo) bool
+	//StripComponents removes that many leading elements from each path, relative to the File being extracted, like tar's --strip-components.
+	//Paths with no elements left aren't extracted, though a directory's contents still are.
+	StripComponents int
+	//Transform, if set, is given each path, relative to the File being extracted, after StripComponents and returns where it's extracted to instead.
+	//Returning "" skips the path, the same as StripComponents. Paths outside of the extraction folder return ErrUnsafePath.
+	Transform func(path string) string
+	//OnConflict is what to do when something already exists where a file is being extracted.
+	OnConflict ConflictPolicy
+
+	state    *extractState
+	file     *extractFile
+	name     string //The path relative to the File being extracted
+	included bool
+}
+
+//rewrites returns if StripComponents or Transform change where files are extracted to.
+func (op ExtractionOptions) rewrites() bool {
+	return op.StripComponents > 0 || op.Transform != nil
+}
+
+//destPath returns where name, relative to the File being extracted, is extracted to after StripComponents and Transform.
+//Returns "" if name shouldn't be extracted.
+func (op ExtractionOptions) destPath(name string) string {
+	split := strings.Split(name, "/")
+	if op.StripComponents >= len(split) {
+		return ""
+	}
+	name = strings.Join(split[op.StripComponents:], "/")
+	if op.Transform != nil {
+		name = op.Transform(name)
+	}
+	name = filepath.Clean(filepath.FromSlash(name))
+	if name == "." {
+		return ""
+	}
+	return name
 }
 
+//DevicePolicy is what to do when extracting a device, fifo, or socket that can't be created.
+type DevicePolicy uint8
+
+const (
+	DeviceError       = DevicePolicy(iota) //Return ErrMknod
+	DeviceSkip                             //Don't extract the file
+	DevicePlaceholder                      //Create an empty regular file in it's place
+)
+
+//Ownership is when to give extracted files the uid and gid stored in the archive.
+type Ownership uint8
+
+const (
+	OwnershipAuto   = Ownership(iota) //Only when running as root
+	OwnershipAlways                   //Always. Extraction fails if the uid and gid can't be set.
+	OwnershipNever                    //Never. Files are owned by the current user.
+)
+
+//ConflictPolicy is what to do when something already exists where a file, directory, or symlink is being extracted.
+//Existing directories are extracted into instead of being replaced, so the policy only decides if their attributes are changed.
+//Anything else is removed before it's replaced. Directories are only removed if they're empty, otherwise extraction fails with ErrNotEmpty.
+type ConflictPolicy uint8
+
+const (
+	ConflictOverwrite = ConflictPolicy(iota) //Replace what's there
+	ConflictSkip                             //Leave what's there alone. Added to ExtractionSummary.Skipped.
+	ConflictError                            //Return a *fs.PathError with fs.ErrExist
+	ConflictNewer                            //Replace what's there only if the archive's modification time is newer, otherwise skip it
+	ConflictRename                           //Extract next to what's there instead, with the first free suffix of ".1", ".2", and so on
+)
+
 //DefaultOptions is the default ExtractionOptions.
 func DefaultOptions() ExtractionOptions {
 	return ExtractionOptions{
@@ -194,158 +333,418 @@ func DefaultOptions() ExtractionOptions {
 //ExtractTo extracts the File to the given folder with the default options.
 //If the File is a directory, it instead extracts the directory's contents to the folder.
 func (f File) ExtractTo(folder string) error {
-	return f.ExtractWithOptions(folder, DefaultOptions())
+	_, err := f.ExtractWithOptions(folder, DefaultOptions())
+	return err
 }
 
 //ExtractSymlink extracts the File to the folder with the DereferenceSymlink option.
 //If the File is a directory, it instead extracts the directory's contents to the folder.
 func (f File) ExtractSymlink(folder string) error {
-	return f.ExtractWithOptions(folder, ExtractionOptions{
+	_, err := f.ExtractWithOptions(folder, ExtractionOptions{
 		DereferenceSymlink: true,
 		FolderPerm:         0755,
 	})
+	return err
 }
 
-//ExtractWithOptions extracts the File to the given folder with the given ExtrationOptions.
+//ExtractWithOptions extracts the File to the given folder with the given ExtrationOptions and returns a summary of what was extracted.
 //If the File is a directory, it instead extracts the directory's contents to the folder.
-func (f File) ExtractWithOptions(folder string, op ExtractionOptions) error {
+func (f File) ExtractWithOptions(folder string, op ExtractionOptions) (ExtractionSummary, error) {
+	return f.ExtractWithOptionsContext(context.Background(), folder, op)
+}
+
+//ExtractContext is ExtractTo, but stops once ctx is done.
+func (f File) ExtractContext(ctx context.Context, folder string) error {
+	_, err := f.ExtractWithOptionsContext(ctx, folder, DefaultOptions())
+	return err
+}
+
+//ExtractWithOptionsContext is ExtractWithOptions, but stops once ctx is done and returns ctx.Err().
+//Everything being extracted is stopped, and finished, before returning. Files that were already extracted are left in place.
+func (f File) ExtractWithOptionsContext(ctx context.Context, folder string, op ExtractionOptions) (ExtractionSummary, error) {
+	start := time.Now()
 	if op.Verbose {
 		if op.LogOutput == nil {
 			op.LogOutput = os.Stdout
 		}
 		log.SetOutput(op.LogOutput)
 	}
-	return f.realExtract(folder, op)
+	op.state = newExtractState(ctx, folder, op.Progress, op.Workers)
+	defer op.state.cancel()
+	op.state.workers <- struct{}{}
+	err := ctx.Err()
+	if err != nil {
+		return ExtractionSummary{}, err
+	}
+	op.state.filter, err = newExtractFilter(op)
+	if err != nil {
+		return ExtractionSummary{}, err
+	}
+	err = os.MkdirAll(folder, op.FolderPerm)
+	if err != nil {
+		if op.Verbose {
+			log.Println("Error while creating extraction folder")
+		}
+		return ExtractionSummary{}, err
+	}
+	err = op.state.openRoot()
+	if err != nil {
+		return ExtractionSummary{}, err
+	}
+	defer op.state.closeRoot()
+	if op.Progress != nil {
+		op.state.total, err = f.extractSize(op)
+		if err != nil {
+			return ExtractionSummary{}, err
+		}
+	}
+	err = f.realExtract(folder, op)
+	if op.state.err != nil {
+		err = op.state.err
+	}
+	op.state.summary.Duration = time.Since(start)
+	return op.state.summary, err
 }
 
-func (f File) realExtract(folder string, op ExtractionOptions) error {
-	err := os.MkdirAll(folder, op.FolderPerm)
-	if err != nil && !os.IsExist(err) {
+func (f File) realExtract(folder string, op ExtractionOptions) (err error) {
+	err = op.state.ctx.Err()
+	if err != nil {
+		return err
+	}
+	err = op.state.mkdirBeneath(folder, op.FolderPerm)
+	if err != nil {
 		if op.Verbose {
-			log.Println("Error while creating extraction folder")
+			log.Println("Error while creating", folder)
 		}
 		return err
 	}
 	folder = filepath.Clean(folder)
-	if f.IsDir() {
-		filFS, _ := f.FS()
-		var ents []directory.Entry
-		ents, err = f.r.readDirectory(f.i)
+	if !f.IsDir() {
+		path := filepath.Join(folder, f.e.Name)
+		if !validName(f.e.Name) {
+			return unsafePath(path)
+		}
+		var d *destDir
+		d, err = op.state.openDir(folder)
 		if err != nil {
-			if op.Verbose {
-				log.Println("Error while reading children of", f.path())
-			}
 			return err
 		}
-		errChan := make(chan error)
-		for i := 0; i < len(ents); i++ {
+		defer d.close()
+		//The same file can be extracted to the same place more then once, such as a symlink's target with UnbreakSymlink.
+		if !op.state.claim(path, f.i.Num) {
+			return nil
+		}
+		if op.CopyHardLinks || linkCount(f.i) < 2 {
+			_, err = f.extractEntry(d, op)
+			return err
+		}
+		//Hard links are resolved first, so OnConflict is only applied to what's actually created.
+		l, first := op.state.hardLink(f.i.Num, path)
+		if !first {
+			return f.extractHardLink(l, d, op)
+		}
+		defer l.finish(&err)
+		l.path, err = f.extractEntry(d, op)
+		return err
+	}
+	filFS, _ := f.FS()
+	var ents []directory.Entry
+	ents, err = f.r.readDirectory(f.i)
+	if err != nil {
+		if op.Verbose {
+			log.Println("Error while reading children of", f.path())
+		}
+		return err
+	}
+	//A crafted archive can have names that point elsewhere, such as "..", or the same name twice so a symlink replaces a directory.
+	names := make(map[string]bool, len(ents))
+	for _, ent := range ents {
+		if !validName(ent.Name) || names[ent.Name] {
+			return unsafePath(filepath.Join(folder, ent.Name))
+		}
+		names[ent.Name] = true
+	}
+	//Buffered so nothing's left waiting if we stop early.
+	errChan := make(chan error, len(ents))
+	for i := 0; i < len(ents); i++ {
+		childOp := op
+		childOp.name = ents[i].Name
+		if op.name != "" {
+			childOp.name = op.name + "/" + ents[i].Name
+		}
+		var keep bool
+		keep, childOp.included, err = op.state.filter.keep(f.r, childOp.name, ents[i], op.included)
+		if err != nil || !keep {
+			errChan <- err
+			continue
+		}
+		//Children are only extracted in a new goroutine if there's a free worker. Otherwise they're extracted here, so nothing waits on a worker.
+		if op.state.acquire() {
 			go func(ent directory.Entry) {
-				fil, goErr := f.r.newFile(ent, filFS)
-				if goErr != nil {
-					if op.Verbose {
-						log.Println("Error while reading info for", filepath.Join(f.path(), ent.Name))
-					}
-					errChan <- goErr
-					return
-				}
-				if fil.IsDir() {
-					info, _ := fil.Stat()
-					err = os.Mkdir(filepath.Join(folder, fil.e.Name), info.Mode())
-					if err != nil {
-						if op.Verbose {
-							log.Println("Error while creating", filepath.Join(folder, fil.e.Name))
-						}
-						errChan <- err
-						return
-					}
-					errChan <- fil.realExtract(filepath.Join(folder, fil.e.Name), op)
-				} else {
-					errChan <- fil.realExtract(folder, op)
-				}
-				fil.Close()
+				err := f.extractChild(ent, filFS, folder, childOp)
+				op.state.release()
+				errChan <- err
 			}(ents[i])
+			continue
+		}
+		err = f.extractChild(ents[i], filFS, folder, childOp)
+		if err != nil {
+			op.state.fail(err)
 		}
+		errChan <- err
+	}
+	//Wait for everything, even after an error, so nothing is still being extracted once we return.
+	var firstErr error
+	op.state.idle(func() {
 		for i := 0; i < len(ents); i++ {
 			err = <-errChan
-			if err != nil {
-				return err
+			if err != nil && firstErr == nil {
+				firstErr = err
+				op.state.fail(err)
 			}
 		}
-		return nil
-	} else if f.IsRegular() {
-		var fil *os.File
-		fil, err = os.Create(folder + "/" + f.e.Name)
-		if os.IsExist(err) {
-			os.Remove(folder + "/" + f.e.Name)
-			fil, err = os.Create(folder + "/" + f.e.Name)
-			if err != nil {
-				if op.Verbose {
-					log.Println("Error while creating", folder+"/"+f.e.Name)
-				}
-				return err
+	})
+	return firstErr
+}
+
+//extractEntry extracts the File, which isn't a directory, to d after applying op.OnConflict.
+//Returns the path it was extracted to, or "" if it was skipped.
+func (f File) extractEntry(d *destDir, op ExtractionOptions) (path string, err error) {
+	path = d.join(f.e.Name)
+	//Dereferenced symlinks are extracted, and reported, as the file they point to.
+	if f.IsSymlink() && op.DereferenceSymlink {
+		fil := f.GetSymlinkFile()
+		if fil == nil {
+			if op.Verbose {
+				log.Println("Symlink path(", f.SymlinkPath(), ") is unobtainable:", path)
 			}
-		} else if err != nil {
+			return "", errors.New("cannot get symlink target")
+		}
+		fil.e.Name = f.e.Name
+		err = fil.realExtract(d.path, op)
+		if err != nil {
+			if op.Verbose {
+				log.Println("Error while extracting the symlink's file:", path)
+			}
+			return "", err
+		}
+		return path, nil
+	}
+	var c conflict
+	f.e.Name, c, err = f.conflict(d, f.e.Name, op)
+	path = d.join(f.e.Name)
+	if err != nil {
+		return "", err
+	} else if c == conflictSkip {
+		if op.Verbose {
+			log.Println("Skipping", path, "since it already exists")
+		}
+		op.state.skip(&extractFile{path: path})
+		return "", nil
+	}
+	op.file = op.state.start(path, typeMode(f.i.Type), fileSize(f.i))
+	defer op.state.finish(op.file, &err)
+	return path, f.create(d, op)
+}
+
+//create creates the File, which isn't a directory, in d.
+func (f File) create(d *destDir, op ExtractionOptions) (err error) {
+	path := d.join(f.e.Name)
+	if f.IsRegular() {
+		var fil *os.File
+		fil, err = d.create(f.e.Name, 0644)
+		if err != nil {
 			if op.Verbose {
-				log.Println("Error while creating", folder+"/"+f.e.Name)
+				log.Println("Error while creating", path)
 			}
 			return err
 		}
-		_, err = io.Copy(fil, f)
+		defer fil.Close()
+		//Holes are skipped over, so sparse files stay sparse.
+		_, err = f.fullRdr.WriteToSparseWorkers(op.state.ctx, progressWriter{
+			File: fil,
+			s:    op.state,
+			f:    op.file,
+		}, op.state.workers)
 		if err != nil {
 			if op.Verbose {
-				log.Println("Error while copying data to", folder+"/"+f.e.Name)
+				log.Println("Error while copying data to", path)
 			}
 			return err
 		}
-		return nil
+		err = fil.Close()
+		if err != nil {
+			return err
+		}
+		return f.restore(d, f.e.Name, op)
 	} else if f.IsSymlink() {
 		symPath := f.SymlinkPath()
-		if op.DereferenceSymlink {
+		if op.UnbreakSymlink {
 			fil := f.GetSymlinkFile()
 			if fil == nil {
 				if op.Verbose {
-					log.Println("Symlink path(", symPath, ") is unobtainable:", folder+"/"+f.e.Name)
+					log.Println("Symlink path(", symPath, ") is unobtainable:", path)
 				}
 				return errors.New("cannot get symlink target")
 			}
-			fil.e.Name = f.e.Name
-			err = fil.realExtract(folder, op)
+			extractLoc := filepath.Join(d.path, filepath.Dir(symPath))
+			err = fil.realExtract(extractLoc, op)
 			if err != nil {
 				if op.Verbose {
-					log.Println("Error while extracting the symlink's file:", folder+"/"+f.e.Name)
+					log.Println("Error while extracting ", path)
 				}
 				return err
 			}
+		}
+		err = d.symlink(symPath, f.e.Name)
+		if err != nil {
+			if op.Verbose {
+				log.Println("Error while making symlink:", path)
+			}
+			return err
+		}
+		return f.restore(d, f.e.Name, op)
+	} else if f.IsSpecial() {
+		return f.extractSpecial(d, op)
+	}
+	return errors.New("Unsupported file type. Inode type: " + strconv.Itoa(int(f.i.Type)))
+}
+
+//extractChild extracts the directory's child ent to folder.
+func (f File) extractChild(ent directory.Entry, filFS *FS, folder string, op ExtractionOptions) error {
+	err := op.state.ctx.Err()
+	if err != nil {
+		return err
+	}
+	fil, err := f.r.newFile(ent, filFS)
+	if err != nil {
+		if op.Verbose {
+			log.Println("Error while reading info for", filepath.Join(f.path(), ent.Name))
+		}
+		return err
+	}
+	defer fil.Close()
+	if op.rewrites() {
+		dest := op.destPath(op.name)
+		if dest == "" {
+			if op.Verbose {
+				log.Println("Skipping", op.name)
+			}
+			if !fil.IsDir() {
+				return nil
+			}
+			//The directory itself is skipped, but not it's contents.
+			return fil.realExtract(op.state.root, op)
+		}
+		dest = filepath.Join(op.state.root, dest)
+		folder = filepath.Dir(dest)
+		fil.e.Name = filepath.Base(dest)
+		if fil.IsDir() {
+			err = op.state.mkdirBeneath(folder, op.FolderPerm)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if !fil.IsDir() {
+		return fil.realExtract(folder, op)
+	}
+	dirPath := filepath.Join(folder, fil.e.Name)
+	//The archive's permissions are applied after the directory's contents are extracted, in case they're read-only.
+	perm := fs.FileMode(0700)
+	if op.IgnorePerm {
+		perm = op.FolderPerm
+	}
+	var c conflict
+	d, err := op.state.openDir(folder)
+	if err == nil {
+		//If the directory was already extracted here, such as with UnbreakSymlink, it's extracted into again.
+		if !op.state.claim(dirPath, fil.i.Num) {
+			c = conflictKeep
+		} else {
+			fil.e.Name, c, err = fil.conflict(d, fil.e.Name, op)
+			dirPath = d.join(fil.e.Name)
+		}
+		if err == nil && c == conflictNone {
+			err = d.mkdir(fil.e.Name, perm)
+		}
+		d.close()
+	}
+	if err != nil {
+		if op.Verbose {
+			log.Println("Error while creating", dirPath)
+		}
+		return err
+	}
+	switch c {
+	case conflictSkip:
+		if op.Verbose {
+			log.Println("Skipping", dirPath, "since it already exists")
+		}
+		op.state.skip(&extractFile{path: dirPath})
+		return nil
+	case conflictNone:
+		op.state.created()
+	}
+	err = fil.realExtract(dirPath, op)
+	if err != nil || c == conflictKeep {
+		return err
+	}
+	//The folder is opened again, so nothing changed while extracting the directory's contents is followed.
+	d, err = op.state.openDir(folder)
+	if err != nil {
+		return err
+	}
+	defer d.close()
+	return fil.restore(d, fil.e.Name, op)
+}
+
+//extractSpecial creates the device, fifo, or socket in d.
+func (f File) extractSpecial(d *destDir, op ExtractionOptions) error {
+	path := d.join(f.e.Name)
+	perm := uint32(f.i.Perm)
+	if !canMknod(f.i.Type) {
+		switch op.DevicePolicy {
+		case DeviceSkip:
+			if op.Verbose {
+				log.Println("Skipping", path)
+			}
+			op.state.skip(op.file)
 			return nil
-		} else if op.UnbreakSymlink {
-			fil := f.GetSymlinkFile()
-			if fil == nil {
+		case DevicePlaceholder:
+			fil, err := d.create(f.e.Name, permMode(f.i.Perm).Perm())
+			if err != nil {
 				if op.Verbose {
-					log.Println("Symlink path(", symPath, ") is unobtainable:", folder+"/"+f.e.Name)
+					log.Println("Error while creating placeholder", path)
 				}
-				return errors.New("cannot get symlink target")
+				return err
 			}
-			extractLoc := filepath.Clean(folder + "/" + filepath.Dir(symPath))
-			err = fil.realExtract(extractLoc, op)
+			op.state.warn(path, "can't be created, extracted an empty placeholder instead")
+			op.file.typ = 0
+			err = fil.Close()
 			if err != nil {
-				if op.Verbose {
-					log.Println("Error while extracting ", folder+"/"+f.e.Name)
-				}
 				return err
 			}
+			return f.restore(d, f.e.Name, op)
 		}
-		err = os.Symlink(f.SymlinkPath(), folder+"/"+f.e.Name)
-		if os.IsExist(err) {
-			os.Remove(folder + "/" + f.e.Name)
-			err = os.Symlink(f.SymlinkPath(), folder+"/"+f.e.Name)
+		if op.Verbose {
+			log.Println("Cannot create", path)
 		}
-		if err != nil {
-			if op.Verbose {
-				log.Println("Error while making symlink:", folder+"/"+f.e.Name)
-			}
-			return err
+		return ErrMknod
+	}
+	var dev uint32
+	switch d := f.i.Data.(type) {
+	case inode.Device:
+		dev = d.Dev
+	case inode.EDevice:
+		dev = d.Dev
+	}
+	err := d.mknod(f.e.Name, f.i.Type, perm, dev)
+	if err != nil {
+		if op.Verbose {
+			log.Println("Error while creating", path)
 		}
-		return nil
+		return err
 	}
-	return errors.New("Unsupported file type. Inode type: " + strconv.Itoa(int(f.i.Type)))
+	return f.restore(d, f.e.Name, op)
 }
@@ -8,10 +8,63 @@ import (
 	"github.com/CalebQ42/squashfs/internal/inode"
 )
 
+//Inode types, as reported by InodeInfo.Type.
+const (
+	DirType = uint16(iota + 1)
+	FileType
+	SymlinkType
+	BlockType
+	CharType
+	FifoType
+	SocketType
+	EDirType
+	EFileType
+	ESymlinkType
+	EBlockType
+	ECharType
+	EFifoType
+	ESocketType
+)
+
+//InodeInfo is the extra information about a file that is returned by fs.FileInfo.Sys().
+type InodeInfo struct {
+	//Sparse is the number of bytes saved by sparse blocks. Only set for extended files.
+	Sparse uint64
+	Uid    uint32
+	Gid    uint32
+	//Inode is the inode number. Files that are hard linked share the same inode number.
+	Inode uint32
+	//Nlink is the number of directory entries that refer to the inode.
+	Nlink uint32
+	//XattrIndex is the inode's index in the xattr table. 0xFFFFFFFF if there are no xattrs.
+	XattrIndex uint32
+	//DevMajor and DevMinor are the device numbers of block and char devices.
+	DevMajor uint32
+	DevMinor uint32
+	//DirSize is the size of a directory's listing in the directory table, as stored in the inode.
+	DirSize uint32
+	//Type is the inode's type. One of the *Type constants.
+	Type uint16
+
+	r *Reader
+	i inode.Inode
+}
+
+//Xattrs returns the file's extended attributes. If it has none, returns nil.
+//They aren't read from the archive until Xattrs is called.
+func (i InodeInfo) Xattrs() (map[string][]byte, error) {
+	if i.r == nil {
+		return nil, nil
+	}
+	return i.r.xattrs(i.i)
+}
+
 type fileInfo struct {
+	r       *Reader
 	e       directory.Entry
+	i       inode.Inode
 	size    int64
-	perm    uint32
+	perm    uint16
 	modTime uint32
 }
 
@@ -20,20 +73,26 @@ func (r Reader) newFileInfo(e directory.Entry) (fileInfo, error) {
 	if err != nil {
 		return fileInfo{}, err
 	}
-	return newFileInfo(e, i), nil
+	return newFileInfo(&r, e, i), nil
 }
 
-func newFileInfo(e directory.Entry, i inode.Inode) fileInfo {
-	var size int64
+//fileSize returns the size of a regular file's inode. Other types have a size of 0.
+func fileSize(i inode.Inode) int64 {
 	if i.Type == inode.Fil {
-		size = int64(i.Data.(inode.File).Size)
+		return int64(i.Data.(inode.File).Size)
 	} else if i.Type == inode.EFil {
-		size = int64(i.Data.(inode.EFile).Size)
+		return int64(i.Data.(inode.EFile).Size)
 	}
+	return 0
+}
+
+func newFileInfo(r *Reader, e directory.Entry, i inode.Inode) fileInfo {
 	return fileInfo{
+		r:       r,
 		e:       e,
-		size:    size,
-		perm:    uint32(i.Perm),
+		i:       i,
+		size:    fileSize(i),
+		perm:    i.Perm,
 		modTime: i.ModTime,
 	}
 }
@@ -47,10 +106,7 @@ func (f fileInfo) Size() int64 {
 }
 
 func (f fileInfo) Mode() fs.FileMode {
-	if f.IsDir() {
-		return fs.FileMode(f.perm | uint32(fs.ModeDir))
-	}
-	return fs.FileMode(f.perm)
+	return typeMode(f.i.Type) | permMode(f.perm)
 }
 
 func (f fileInfo) ModTime() time.Time {
@@ -58,9 +114,101 @@ func (f fileInfo) ModTime() time.Time {
 }
 
 func (f fileInfo) IsDir() bool {
-	return f.e.Type == inode.Dir
+	return f.i.Type == inode.Dir || f.i.Type == inode.EDir
 }
 
+//Sys returns a *InodeInfo.
 func (f fileInfo) Sys() any {
-	return nil
+	out := &InodeInfo{
+		Inode:      f.i.Num,
+		Nlink:      linkCount(f.i),
+		XattrIndex: xattrInd(f.i),
+		Type:       f.i.Type,
+		r:          f.r,
+		i:          f.i,
+	}
+	if int(f.i.UidInd) < len(f.r.ids) {
+		out.Uid = f.r.ids[f.i.UidInd]
+	}
+	if int(f.i.GidInd) < len(f.r.ids) {
+		out.Gid = f.r.ids[f.i.GidInd]
+	}
+	switch d := f.i.Data.(type) {
+	case inode.Directory:
+		out.DirSize = uint32(d.Size)
+	case inode.EDirectory:
+		out.DirSize = d.Size
+	case inode.Device:
+		out.DevMajor, out.DevMinor = decodeDev(d.Dev)
+	case inode.EDevice:
+		out.DevMajor, out.DevMinor = decodeDev(d.Dev)
+	case inode.EFile:
+		out.Sparse = d.Sparse
+	}
+	return out
+}
+
+//typeMode returns the fs.FileMode type bits for an inode type.
+func typeMode(typ uint16) fs.FileMode {
+	switch typ {
+	case inode.Dir, inode.EDir:
+		return fs.ModeDir
+	case inode.Sym, inode.ESym:
+		return fs.ModeSymlink
+	case inode.Block, inode.EBlock:
+		return fs.ModeDevice
+	case inode.Char, inode.EChar:
+		return fs.ModeDevice | fs.ModeCharDevice
+	case inode.Fifo, inode.EFifo:
+		return fs.ModeNamedPipe
+	case inode.Sock, inode.ESock:
+		return fs.ModeSocket
+	}
+	return 0
+}
+
+//permMode converts unix permission bits, including setuid, setgid, and sticky, to a fs.FileMode.
+func permMode(perm uint16) fs.FileMode {
+	mode := fs.FileMode(perm & 0777)
+	if perm&04000 != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if perm&02000 != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if perm&01000 != 0 {
+		mode |= fs.ModeSticky
+	}
+	return mode
+}
+
+//linkCount returns the number of directory entries that refer to the inode.
+func linkCount(i inode.Inode) uint32 {
+	switch d := i.Data.(type) {
+	case inode.Directory:
+		return d.LinkCount
+	case inode.EDirectory:
+		return d.LinkCount
+	case inode.EFile:
+		return d.LinkCount
+	case inode.Symlink:
+		return d.LinkCount
+	case inode.ESymlink:
+		return d.LinkCount
+	case inode.Device:
+		return d.LinkCount
+	case inode.EDevice:
+		return d.LinkCount
+	case inode.IPC:
+		return d.LinkCount
+	case inode.EIPC:
+		return d.LinkCount
+	}
+	//Basic files don't store a link count.
+	return 1
+}
+
+//decodeDev decodes a device number the same way as Linux's new_decode_dev.
+func decodeDev(dev uint32) (major, minor uint32) {
+	return (dev & 0xfff00) >> 8, (dev & 0xff) | ((dev >> 12) & 0xfff00)
 }
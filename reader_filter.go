@@ -0,0 +1,118 @@
+package squashfs
+
+import (
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/CalebQ42/squashfs/internal/directory"
+	"github.com/CalebQ42/squashfs/internal/inode"
+)
+
+//extractFilter decides what's extracted using ExtractionOptions' Include, Exclude, and Filter.
+type extractFilter struct {
+	filter  func(string, fs.FileInfo) bool
+	include [][]string
+	exclude [][]string
+}
+
+//newExtractFilter returns the extractFilter for op. If op doesn't filter anything, returns nil.
+func newExtractFilter(op ExtractionOptions) (*extractFilter, error) {
+	if len(op.Include) == 0 && len(op.Exclude) == 0 && op.Filter == nil {
+		return nil, nil
+	}
+	e := &extractFilter{
+		filter: op.Filter,
+	}
+	var err error
+	e.include, err = splitPatterns(op.Include)
+	if err != nil {
+		return nil, err
+	}
+	e.exclude, err = splitPatterns(op.Exclude)
+	return e, err
+}
+
+//splitPatterns splits each pattern into it's elements and makes sure they're valid.
+func splitPatterns(patterns []string) (out [][]string, err error) {
+	for _, p := range patterns {
+		split := strings.Split(strings.Trim(p, "/"), "/")
+		for _, el := range split {
+			if _, err = path.Match(el, ""); err != nil {
+				return nil, &fs.PathError{
+					Op:   "extract",
+					Path: p,
+					Err:  err,
+				}
+			}
+		}
+		out = append(out, split)
+	}
+	return
+}
+
+//matchGlob returns if the elements of name match pattern. "**" matches any number of elements, including none.
+//If partial, also returns true if name is a directory that could contain a match.
+func matchGlob(pattern, name []string, partial bool) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchGlob(pattern[1:], name[i:], partial) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return partial
+		}
+		if match, _ := path.Match(pattern[0], name[0]); !match {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+//keep returns if the directory entry ent should be extracted. name is it's path, relative to the extraction folder.
+//included is if ent's parent matched an Include pattern. all is if ent, and so everything in it, matched an Include pattern.
+//The patterns only need ent, so an excluded directory's inode is never read. Filter is only called if the patterns match.
+func (e *extractFilter) keep(r *Reader, name string, ent directory.Entry, included bool) (extract bool, all bool, err error) {
+	if e == nil {
+		return true, true, nil
+	}
+	split := strings.Split(name, "/")
+	for _, ex := range e.exclude {
+		if matchGlob(ex, split, false) {
+			return false, false, nil
+		}
+	}
+	if len(e.include) == 0 {
+		included = true
+	}
+	for i := 0; !included && i < len(e.include); i++ {
+		included = matchGlob(e.include[i], split, false)
+	}
+	if !included {
+		if ent.Type != inode.Dir {
+			return false, false, nil
+		}
+		var inside bool
+		for i := 0; !inside && i < len(e.include); i++ {
+			inside = matchGlob(e.include[i], split, true)
+		}
+		if !inside {
+			return false, false, nil
+		}
+	}
+	if e.filter != nil {
+		info, err := r.newFileInfo(ent)
+		if err != nil {
+			return false, false, err
+		}
+		if !e.filter(name, info) {
+			return false, false, nil
+		}
+	}
+	return true, included, nil
+}
@@ -13,7 +13,13 @@ type fragEntry struct {
 }
 
 func (r Reader) fragReader(index uint32) (io.Reader, error) {
+	if int(index) >= len(r.fragEntries) {
+		return nil, ErrorFragment
+	}
 	realSize := r.fragEntries[index].Size &^ (1 << 24)
+	if realSize > r.s.BlockSize {
+		return nil, ErrorDataBlock
+	}
 	rdr := io.LimitReader(toreader.NewReader(r.r, int64(r.fragEntries[index].Start)), int64(realSize))
 	if realSize != r.fragEntries[index].Size {
 		return rdr, nil
@@ -2,7 +2,7 @@ package squashfs
 
 import (
 	"bytes"
-	"io"
+	"context"
 	"io/fs"
 	"path"
 	"path/filepath"
@@ -19,14 +19,14 @@ type FS struct {
 	e []directory.Entry
 }
 
+//newFS creates the FS for the directory entry e. The directory's entries aren't read until they're needed.
 func (r Reader) newFS(e directory.Entry, parent *FS) (*FS, error) {
 	i, err := r.inodeFromDir(e)
 	if err != nil {
 		return nil, err
 	}
-	ents, err := r.readDirectory(i)
-	if err != nil {
-		return nil, err
+	if parent.isAncestor(i) {
+		return nil, ErrorLoop
 	}
 	return &FS{
 		File: &File{
@@ -35,10 +35,52 @@ func (r Reader) newFS(e directory.Entry, parent *FS) (*FS, error) {
 			parent: parent,
 			e:      e,
 		},
-		e: ents,
 	}, nil
 }
 
+//isAncestor returns if the directory i is f or one of it's parents. A crafted archive can have a directory contain itself, which would otherwise never end.
+func (f *FS) isAncestor(i inode.Inode) bool {
+	if i.Type != inode.Dir && i.Type != inode.EDir {
+		return false
+	}
+	for ; f != nil; f = f.parent {
+		if f.i.Num == i.Num {
+			return true
+		}
+	}
+	return false
+}
+
+//entries returns all of the directory's entries.
+func (f FS) entries() ([]directory.Entry, error) {
+	if f.e != nil {
+		return f.e, nil
+	}
+	return f.r.readDirectory(f.i)
+}
+
+//walk finds the entry at name and returns it, along with the FS of the directory it's in.
+//name must be cleaned and not be ".". If it doesn't exist, returns fs.ErrNotExist.
+func (f FS) walk(name string) (*FS, directory.Entry, error) {
+	dir := &f
+	split := strings.Split(name, "/")
+	for _, part := range split[:len(split)-1] {
+		en, err := dir.r.lookup(dir.i, part)
+		if err != nil {
+			return nil, en, err
+		}
+		if en.Type != inode.Dir {
+			return nil, en, fs.ErrNotExist
+		}
+		dir, err = dir.r.newFS(en, dir)
+		if err != nil {
+			return nil, en, err
+		}
+	}
+	en, err := dir.r.lookup(dir.i, split[len(split)-1])
+	return dir, en, err
+}
+
 //Open opens the file at name. Returns a squashfs.File.
 func (f FS) Open(name string) (fs.File, error) {
 	if !fs.ValidPath(name) {
@@ -52,48 +94,23 @@ func (f FS) Open(name string) (fs.File, error) {
 	if name == "." || name == "" {
 		return f.File, nil
 	}
-	split := strings.Split(name, "/")
-	for i := range f.e {
-		if f.e[i].Name != split[0] {
-			continue
-		}
-		if len(split) > 1 && f.e[i].Type != inode.Dir {
-			return nil, &fs.PathError{
-				Op:   "open",
-				Path: name,
-				Err:  fs.ErrNotExist,
-			}
-		}
-		if len(split) > 1 {
-			newFS, err := f.r.newFS(f.e[i], &f)
-			if err != nil {
-				return nil, &fs.PathError{
-					Op:   "open",
-					Path: name,
-					Err:  err,
-				}
-			}
-			out, err := newFS.Open(strings.Join(split[1:], "/"))
-			if err != nil {
-				err.(*fs.PathError).Path = name
-			}
-			return out, err
-		}
-		out, err := f.r.newFile(f.e[i], &f)
-		if err != nil {
-			err = &fs.PathError{
-				Op:   "open",
-				Path: name,
-				Err:  err,
-			}
+	dir, en, err := f.walk(name)
+	if err != nil {
+		return nil, &fs.PathError{
+			Op:   "open",
+			Path: name,
+			Err:  err,
 		}
-		return out, err
 	}
-	return nil, &fs.PathError{
-		Op:   "open",
-		Path: name,
-		Err:  fs.ErrNotExist,
+	out, err := f.r.newFile(en, dir)
+	if err != nil {
+		return nil, &fs.PathError{
+			Op:   "open",
+			Path: name,
+			Err:  err,
+		}
 	}
+	return out, nil
 }
 
 //Glob returns the name of the files at the given pattern.
@@ -109,13 +126,24 @@ func (f FS) Glob(pattern string) (out []string, err error) {
 	}
 	pattern = filepath.Clean(pattern)
 	split := strings.Split(pattern, "/")
-	for i := 0; i < len(f.e); i++ {
-		if match, _ := path.Match(split[0], f.e[i].Name); match {
+	ents, err := f.entries()
+	if err != nil {
+		return nil, &fs.PathError{
+			Op:   "glob",
+			Path: pattern,
+			Err:  err,
+		}
+	}
+	for i := 0; i < len(ents); i++ {
+		if match, _ := path.Match(split[0], ents[i].Name); match {
 			if len(split) == 1 {
-				out = append(out, f.e[i].Name)
+				out = append(out, ents[i].Name)
 				continue
 			}
-			sub, err := f.Sub(split[0])
+			if ents[i].Type != inode.Dir {
+				continue
+			}
+			sub, err := f.Sub(ents[i].Name)
 			if err != nil {
 				if pathErr, ok := err.(*fs.PathError); ok {
 					if pathErr.Err == fs.ErrNotExist {
@@ -131,7 +159,7 @@ func (f FS) Glob(pattern string) (out []string, err error) {
 					Err:  err,
 				}
 			}
-			subGlob, err := sub.(FS).Glob(strings.Join(split[1:], "/"))
+			subGlob, err := sub.(*FS).Glob(strings.Join(split[1:], "/"))
 			if err != nil {
 				if pathErr, ok := err.(*fs.PathError); ok {
 					if pathErr.Err == fs.ErrNotExist {
@@ -147,8 +175,8 @@ func (f FS) Glob(pattern string) (out []string, err error) {
 					Err:  err,
 				}
 			}
-			for i := 0; i < len(subGlob); i++ {
-				subGlob[i] = f.File.e.Name + "/" + subGlob[i]
+			for j := 0; j < len(subGlob); j++ {
+				subGlob[j] = ents[i].Name + "/" + subGlob[j]
 			}
 			out = append(out, subGlob...)
 		}
@@ -170,72 +198,40 @@ func (f FS) ReadDir(name string) ([]fs.DirEntry, error) {
 	if name == "." || name == "" {
 		return f.File.ReadDir(-1)
 	}
-	split := strings.Split(name, "/")
-	for i := 0; i < len(f.e); i++ {
-		if split[0] == f.e[i].Name {
-			if len(split) == 1 {
-				fi, err := f.r.newFile(f.e[i], &f)
-				if err != nil {
-					return nil, &fs.PathError{
-						Op:   "readdir",
-						Path: name,
-						Err:  err,
-					}
-				}
-				out, err := fi.ReadDir(-1)
-				if err != nil {
-					err = &fs.PathError{
-						Op:   "readdir",
-						Path: name,
-						Err:  err,
-					}
-				}
-				return out, err
-			}
-			sub, err := f.Sub(split[0])
-			if err != nil {
-				if pathErr, ok := err.(*fs.PathError); ok {
-					if pathErr.Err == fs.ErrNotExist {
-						continue
-					}
-					pathErr.Op = "readir"
-					pathErr.Path = name
-					return nil, pathErr
-				}
-				return nil, &fs.PathError{
-					Op:   "readdir",
-					Path: name,
-					Err:  err,
-				}
-			}
-			redDir, err := sub.(FS).ReadDir(strings.Join(split[1:], "/"))
-			if err != nil {
-				if pathErr, ok := err.(*fs.PathError); ok {
-					if pathErr.Err == fs.ErrNotExist {
-						continue
-					}
-					pathErr.Op = "readdir"
-					pathErr.Path = name
-					return nil, pathErr
-				}
-				return nil, &fs.PathError{
-					Op:   "readdir",
-					Path: name,
-					Err:  err,
-				}
-			}
-			return redDir, nil
+	dir, en, err := f.walk(name)
+	if err != nil {
+		return nil, &fs.PathError{
+			Op:   "readdir",
+			Path: name,
+			Err:  err,
 		}
 	}
-	return nil, &fs.PathError{
-		Op:   "readdir",
-		Path: name,
-		Err:  fs.ErrNotExist,
+	fi, err := f.r.newFile(en, dir)
+	if err != nil {
+		return nil, &fs.PathError{
+			Op:   "readdir",
+			Path: name,
+			Err:  err,
+		}
 	}
+	out, err := fi.ReadDir(-1)
+	if err != nil {
+		err = &fs.PathError{
+			Op:   "readdir",
+			Path: name,
+			Err:  err,
+		}
+	}
+	return out, err
 }
 
 //ReadFile returns the data (in []byte) for the file at name.
 func (f FS) ReadFile(name string) ([]byte, error) {
+	return f.ReadFileContext(context.Background(), name)
+}
+
+//ReadFileContext is ReadFile, but stops decompressing and returns ctx.Err() once ctx is done.
+func (f FS) ReadFileContext(ctx context.Context, name string) ([]byte, error) {
 	fil, err := f.Open(name)
 	if err != nil {
 		if pathErr, ok := err.(*fs.PathError); ok {
@@ -243,9 +239,10 @@ func (f FS) ReadFile(name string) ([]byte, error) {
 			pathErr.Path = name
 			return nil, pathErr
 		}
+		return nil, err
 	}
 	var buf bytes.Buffer
-	_, err = io.Copy(&buf, fil)
+	_, err = fil.(*File).WriteToContext(ctx, &buf)
 	if err != nil {
 		return nil, &fs.PathError{
 			Op:   "readfile",
@@ -269,59 +266,55 @@ func (f FS) Stat(name string) (fs.FileInfo, error) {
 	if name == "." || name == "" {
 		return f.File.Stat()
 	}
-	split := strings.Split(name, "/")
-	for i := 0; i < len(f.e); i++ {
-		if split[0] == f.e[i].Name {
-			if len(split) == 1 {
-				in, err := f.r.newFileInfo(f.e[i])
-				if err != nil {
-					err = &fs.PathError{
-						Op:   "stat",
-						Path: name,
-						Err:  err,
-					}
-				}
-				return in, err
-			}
-			sub, err := f.Sub(split[0])
-			if err != nil {
-				if pathErr, ok := err.(*fs.PathError); ok {
-					if pathErr.Err == fs.ErrNotExist {
-						continue
-					}
-					pathErr.Op = "stat"
-					pathErr.Path = name
-					return nil, pathErr
-				}
-				return nil, &fs.PathError{
-					Op:   "stat",
-					Path: name,
-					Err:  err,
-				}
-			}
-			stat, err := sub.(FS).Stat(strings.Join(split[1:], "/"))
-			if err != nil {
-				if pathErr, ok := err.(*fs.PathError); ok {
-					if pathErr.Err == fs.ErrNotExist {
-						continue
-					}
-					pathErr.Op = "stat"
-					pathErr.Path = name
-					return nil, pathErr
-				}
-				return nil, &fs.PathError{
-					Op:   "stat",
-					Path: name,
-					Err:  err,
-				}
+	_, en, err := f.walk(name)
+	if err != nil {
+		return nil, &fs.PathError{
+			Op:   "stat",
+			Path: name,
+			Err:  err,
+		}
+	}
+	in, err := f.r.newFileInfo(en)
+	if err != nil {
+		return nil, &fs.PathError{
+			Op:   "stat",
+			Path: name,
+			Err:  err,
+		}
+	}
+	return in, nil
+}
+
+//Xattrs returns the extended attributes of the file at name. If it has none, returns nil.
+//FS implements XattrFS, so an archive given to NewWriter keeps it's extended attributes.
+func (f FS) Xattrs(name string) (map[string][]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{
+			Op:   "xattrs",
+			Path: name,
+			Err:  fs.ErrInvalid,
+		}
+	}
+	name = filepath.Clean(strings.TrimPrefix(name, "/"))
+	if name == "." || name == "" {
+		return f.File.Xattrs()
+	}
+	_, en, err := f.walk(name)
+	if err == nil {
+		var i inode.Inode
+		i, err = f.r.inodeFromDir(en)
+		if err == nil {
+			var out map[string][]byte
+			out, err = f.r.xattrs(i)
+			if err == nil {
+				return out, nil
 			}
-			return stat, nil
 		}
 	}
 	return nil, &fs.PathError{
-		Op:   "stat",
+		Op:   "xattrs",
 		Path: name,
-		Err:  fs.ErrNotExist,
+		Err:  err,
 	}
 }
 
@@ -336,40 +329,26 @@ func (f FS) Sub(dir string) (fs.FS, error) {
 	}
 	dir = filepath.Clean(dir)
 	if dir == "." || dir == "" {
-		return f, nil
+		return &f, nil
 	}
-	split := strings.Split(dir, "/")
-	for i := range f.e {
-		if f.e[i].Name != split[0] {
-			continue
-		}
-		if f.e[i].Type != inode.Dir {
-			return nil, &fs.PathError{
-				Op:   "sub",
-				Path: dir,
-				Err:  fs.ErrNotExist,
-			}
-		}
-		newFS, err := f.r.newFS(f.e[i], &f)
-		if err != nil {
-			return nil, &fs.PathError{
-				Op:   "sub",
-				Path: dir,
-				Err:  err,
-			}
-		}
-		if len(split) > 1 {
-			ret, err := newFS.Sub(strings.Join(split[1:], "/"))
-			if err != nil {
-				err.(*fs.PathError).Path = dir
-			}
-			return ret, err
+	parent, en, err := f.walk(dir)
+	if err == nil && en.Type != inode.Dir {
+		err = fs.ErrNotExist
+	}
+	if err != nil {
+		return nil, &fs.PathError{
+			Op:   "sub",
+			Path: dir,
+			Err:  err,
 		}
-		return newFS, nil
 	}
-	return nil, &fs.PathError{
-		Op:   "sub",
-		Path: dir,
-		Err:  fs.ErrNotExist,
+	newFS, err := f.r.newFS(en, parent)
+	if err != nil {
+		return nil, &fs.PathError{
+			Op:   "sub",
+			Path: dir,
+			Err:  err,
+		}
 	}
+	return newFS, nil
 }
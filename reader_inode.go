@@ -3,30 +3,21 @@ package squashfs
 import (
 	"errors"
 	"io"
+	"io/fs"
 
 	"github.com/CalebQ42/squashfs/internal/data"
 	"github.com/CalebQ42/squashfs/internal/directory"
 	"github.com/CalebQ42/squashfs/internal/inode"
 	"github.com/CalebQ42/squashfs/internal/metadata"
-	"github.com/CalebQ42/squashfs/internal/toreader"
 )
 
 func (r Reader) inodeFromRef(ref uint64) (i inode.Inode, err error) {
-	offset, meta := (ref>>16)+r.s.InodeTableStart, ref&0xFFFF
-	rdr := metadata.NewReader(toreader.NewReader(r.r, int64(offset)), r.d)
-	_, err = rdr.Read(make([]byte, meta))
-	if err != nil {
-		return
-	}
+	rdr := metadata.NewReader(r.r, (ref>>16)+r.s.InodeTableStart, uint16(ref), r.d, r.cache)
 	return inode.Read(rdr, r.s.BlockSize)
 }
 
 func (r Reader) inodeFromDir(e directory.Entry) (i inode.Inode, err error) {
-	rdr := metadata.NewReader(toreader.NewReader(r.r, int64(uint64(e.BlockStart)+r.s.InodeTableStart)), r.d)
-	_, err = rdr.Read(make([]byte, e.Offset))
-	if err != nil {
-		return
-	}
+	rdr := metadata.NewReader(r.r, uint64(e.BlockStart)+r.s.InodeTableStart, e.Offset, r.d, r.cache)
 	return inode.Read(rdr, r.s.BlockSize)
 }
 
@@ -35,49 +26,42 @@ func (r Reader) getReaders(i inode.Inode) (full *data.FullReader, rdr *data.Read
 	var blockOffset uint64
 	var blockSizes []uint32
 	var fragInd uint32
-	var fragSize uint32
+	var size uint64
 	if i.Type == inode.Fil {
 		fragOffset = uint64(i.Data.(inode.File).Offset)
 		blockOffset = uint64(i.Data.(inode.File).BlockStart)
 		blockSizes = i.Data.(inode.File).BlockSizes
 		fragInd = i.Data.(inode.File).FragInd
-		fragSize = i.Data.(inode.File).Size % r.s.BlockSize
+		size = uint64(i.Data.(inode.File).Size)
 	} else if i.Type == inode.EFil {
 		fragOffset = uint64(i.Data.(inode.EFile).Offset)
 		blockOffset = i.Data.(inode.EFile).BlockStart
 		blockSizes = i.Data.(inode.EFile).BlockSizes
 		fragInd = i.Data.(inode.EFile).FragInd
-		fragSize = uint32(i.Data.(inode.EFile).Size % uint64(r.s.BlockSize))
+		size = i.Data.(inode.EFile).Size
 	} else {
 		return nil, nil, errors.New("getReaders called on non-file type")
 	}
-	rdr = data.NewReader(toreader.NewReader(r.r, int64(blockOffset)), r.d, blockSizes, r.s.BlockSize)
-	full = data.NewFullReader(r.r, uint64(blockOffset), r.d, blockSizes, r.s.BlockSize)
+	fragSize := size % uint64(r.s.BlockSize)
+	if fragInd != 0xFFFFFFFF && (int(fragInd) >= len(r.fragEntries) || fragOffset+fragSize > uint64(r.s.BlockSize)) {
+		return nil, nil, ErrorFragment
+	}
+	rdr = data.NewReader(r.r, blockOffset, r.d, blockSizes, r.s.BlockSize, size)
+	full = data.NewFullReader(r.r, blockOffset, r.d, blockSizes, r.s.BlockSize, size)
 	if fragInd != 0xFFFFFFFF {
-		full.AddFragment(func() (io.Reader, error) {
-			var fragRdr io.Reader
-			fragRdr, err = r.fragReader(fragInd)
+		frag := func() (io.Reader, error) {
+			fragRdr, err := r.fragReader(fragInd)
 			if err != nil {
 				return nil, err
 			}
-			_, err = fragRdr.Read(make([]byte, fragOffset))
+			_, err = io.CopyN(io.Discard, fragRdr, int64(fragOffset))
 			if err != nil {
 				return nil, err
 			}
-			fragRdr = io.LimitReader(fragRdr, int64(fragSize))
-			return fragRdr, nil
-		})
-		var fragRdr io.Reader
-		fragRdr, err = r.fragReader(fragInd)
-		if err != nil {
-			return nil, nil, err
-		}
-		_, err = fragRdr.Read(make([]byte, fragOffset))
-		if err != nil {
-			return nil, nil, err
+			return io.LimitReader(fragRdr, int64(fragSize)), nil
 		}
-		fragRdr = io.LimitReader(fragRdr, int64(fragSize))
-		rdr.AddFragment(fragRdr)
+		full.AddFragment(frag)
+		rdr.AddFragment(frag)
 	}
 	return
 }
@@ -97,10 +81,53 @@ func (r Reader) readDirectory(i inode.Inode) ([]directory.Entry, error) {
 	} else {
 		return nil, errors.New("readDirectory called on non-directory type")
 	}
-	rdr := metadata.NewReader(toreader.NewReader(r.r, int64(offset+r.s.DirTableStart)), r.d)
-	_, err := rdr.Read(make([]byte, blockOffset))
+	rdr := metadata.NewReader(r.r, offset+r.s.DirTableStart, blockOffset, r.d, r.cache)
+	return directory.ReadEntries(rdr, size)
+}
+
+//lookup finds the entry called name in the directory. If the directory has an index, only the part of the listing that could contain name is read.
+//If name isn't found, returns fs.ErrNotExist.
+func (r Reader) lookup(i inode.Inode, name string) (directory.Entry, error) {
+	var offset uint64
+	var blockOffset uint16
+	var size uint32
+	var indexes []inode.DirectoryIndex
+	if i.Type == inode.Dir {
+		offset = uint64(i.Data.(inode.Directory).BlockStart)
+		blockOffset = i.Data.(inode.Directory).Offset
+		size = uint32(i.Data.(inode.Directory).Size)
+	} else if i.Type == inode.EDir {
+		offset = uint64(i.Data.(inode.EDirectory).BlockStart)
+		blockOffset = i.Data.(inode.EDirectory).Offset
+		size = i.Data.(inode.EDirectory).Size
+		indexes = i.Data.(inode.EDirectory).Indexes
+	} else {
+		return directory.Entry{}, errors.New("lookup called on non-directory type")
+	}
+	if size <= 3 {
+		return directory.Entry{}, fs.ErrNotExist
+	}
+	//Directory sizes are three larger then the actual listing.
+	size -= 3
+	//Each index points to a header, and the name of it's first entry. Start at the last one that's not past name.
+	var skip uint32
+	for _, idx := range indexes {
+		if string(idx.Name) > name || idx.Ind >= size {
+			break
+		}
+		skip = idx.Ind
+		offset = uint64(idx.Start)
+	}
+	if skip > 0 {
+		blockOffset = uint16((uint32(blockOffset) + skip) % metadata.BlockSize)
+	}
+	rdr := metadata.NewReader(r.r, offset+r.s.DirTableStart, blockOffset, r.d, r.cache)
+	e, found, err := directory.Find(rdr, size-skip, name)
 	if err != nil {
-		return nil, err
+		return e, err
 	}
-	return directory.ReadEntries(rdr, size)
+	if !found {
+		return e, fs.ErrNotExist
+	}
+	return e, nil
 }
@@ -0,0 +1,182 @@
+package squashfs
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/CalebQ42/squashfs/internal/inode"
+	"golang.org/x/sys/unix"
+)
+
+//canMknod returns if an inode of the given type can be created. Devices can only be created as root.
+func canMknod(typ uint16) bool {
+	if typ == inode.Block || typ == inode.EBlock || typ == inode.Char || typ == inode.EChar {
+		return os.Geteuid() == 0
+	}
+	return true
+}
+
+//openBeneath opens dir, relative to root, with openat2's RESOLVE_BENEATH to make sure it doesn't resolve to outside of root.
+//Returns ErrUnsafePath if it does. ok is false if openat2 isn't available, such as on kernels before 5.6.
+func openBeneath(root *os.File, dir string) (fd int, ok bool, err error) {
+	how := unix.OpenHow{
+		Flags:   unix.O_RDONLY | unix.O_DIRECTORY | unix.O_CLOEXEC,
+		Resolve: unix.RESOLVE_BENEATH | unix.RESOLVE_NO_MAGICLINKS,
+	}
+	for {
+		fd, err = unix.Openat2(int(root.Fd()), dir, &how)
+		switch err {
+		case nil:
+			return fd, true, nil
+		//Renames while resolving make openat2 fail with EAGAIN. Try again.
+		case unix.EAGAIN, unix.EINTR:
+			continue
+		//Either the kernel is too old, or openat2 is blocked, such as by seccomp.
+		case unix.ENOSYS, unix.EPERM, unix.E2BIG:
+			return -1, false, nil
+		case unix.EXDEV:
+			return -1, true, ErrUnsafePath
+		}
+		return -1, true, &os.PathError{Op: "openat2", Path: dir, Err: err}
+	}
+}
+
+func (d *destDir) close() {
+	if d.fd >= 0 {
+		unix.Close(d.fd)
+	}
+}
+
+func (d *destDir) pathErr(op, name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &os.PathError{Op: op, Path: d.join(name), Err: err}
+}
+
+//lstat returns if name is a directory, and it's modification time, without following symlinks.
+func (d *destDir) lstat(name string) (dir bool, modTime time.Time, err error) {
+	if d.fd < 0 {
+		return lstatPath(d.join(name))
+	}
+	var st unix.Stat_t
+	err = unix.Fstatat(d.fd, name, &st, unix.AT_SYMLINK_NOFOLLOW)
+	if err != nil {
+		return false, time.Time{}, d.pathErr("lstat", name, err)
+	}
+	return st.Mode&unix.S_IFMT == unix.S_IFDIR, time.Unix(st.Mtim.Unix()), nil
+}
+
+//create creates the regular file name. It fails if anything is already there.
+func (d *destDir) create(name string, perm os.FileMode) (*os.File, error) {
+	if d.fd < 0 {
+		return os.OpenFile(d.join(name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	}
+	fd, err := unix.Openat(d.fd, name, unix.O_WRONLY|unix.O_CREAT|unix.O_EXCL|unix.O_NOFOLLOW|unix.O_CLOEXEC, uint32(perm.Perm()))
+	if err != nil {
+		return nil, d.pathErr("open", name, err)
+	}
+	return os.NewFile(uintptr(fd), d.join(name)), nil
+}
+
+func (d *destDir) mkdir(name string, perm os.FileMode) error {
+	if d.fd < 0 {
+		return os.Mkdir(d.join(name), perm)
+	}
+	return d.pathErr("mkdir", name, unix.Mkdirat(d.fd, name, uint32(perm.Perm())))
+}
+
+func (d *destDir) symlink(target, name string) error {
+	if d.fd < 0 {
+		return os.Symlink(target, d.join(name))
+	}
+	return d.pathErr("symlink", name, unix.Symlinkat(target, d.fd, name))
+}
+
+//link creates name as a hard link to oldName in old. Symlinks are linked, not followed.
+func (d *destDir) link(old *destDir, oldName, name string) error {
+	if d.fd < 0 || old.fd < 0 {
+		return os.Link(old.join(oldName), d.join(name))
+	}
+	err := unix.Linkat(old.fd, oldName, d.fd, name, 0)
+	if err != nil {
+		return &os.LinkError{Op: "link", Old: old.join(oldName), New: d.join(name), Err: err}
+	}
+	return nil
+}
+
+//remove removes name, which must be a file or an empty directory.
+func (d *destDir) remove(name string) error {
+	if d.fd < 0 {
+		return os.Remove(d.join(name))
+	}
+	err := unix.Unlinkat(d.fd, name, 0)
+	if err == unix.EISDIR || err == unix.EPERM {
+		err = unix.Unlinkat(d.fd, name, unix.AT_REMOVEDIR)
+	}
+	return d.pathErr("remove", name, err)
+}
+
+//mknod creates a device, fifo, or socket. dev is encoded the same as in the archive.
+func (d *destDir) mknod(name string, typ uint16, perm uint32, dev uint32) error {
+	switch typ {
+	case inode.Block, inode.EBlock:
+		perm |= unix.S_IFBLK
+	case inode.Char, inode.EChar:
+		perm |= unix.S_IFCHR
+	case inode.Fifo, inode.EFifo:
+		perm |= unix.S_IFIFO
+	case inode.Sock, inode.ESock:
+		perm |= unix.S_IFSOCK
+	}
+	if d.fd < 0 {
+		return d.pathErr("mknod", name, unix.Mknod(d.join(name), perm, int(dev)))
+	}
+	return d.pathErr("mknod", name, unix.Mknodat(d.fd, name, perm, int(dev)))
+}
+
+//lchown changes the owner of name without following symlinks.
+func (d *destDir) lchown(name string, uid, gid int) error {
+	if d.fd < 0 {
+		return os.Lchown(d.join(name), uid, gid)
+	}
+	return d.pathErr("lchown", name, unix.Fchownat(d.fd, name, uid, gid, unix.AT_SYMLINK_NOFOLLOW))
+}
+
+//chmod sets the permissions, including setuid, setgid, and sticky, of name. name must not be a symlink.
+func (d *destDir) chmod(name string, perm uint16) error {
+	if d.fd < 0 {
+		return os.Chmod(d.join(name), permMode(perm))
+	}
+	mode := uint32(perm & 07777)
+	err := unix.Fchmodat(d.fd, name, mode, unix.AT_SYMLINK_NOFOLLOW)
+	if err != unix.EOPNOTSUPP {
+		return d.pathErr("chmod", name, err)
+	}
+	//Kernels before 6.6 can't chmod without following symlinks. Instead name is opened without following symlinks
+	//and changed through /proc, which refers to exactly what was opened.
+	fd, err := unix.Openat(d.fd, name, unix.O_PATH|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return d.pathErr("chmod", name, err)
+	}
+	defer unix.Close(fd)
+	var st unix.Stat_t
+	err = unix.Fstat(fd, &st)
+	if err == nil && st.Mode&unix.S_IFMT == unix.S_IFLNK {
+		err = unix.ELOOP
+	}
+	if err == nil {
+		err = unix.Chmod("/proc/self/fd/"+strconv.Itoa(fd), mode)
+	}
+	return d.pathErr("chmod", name, err)
+}
+
+//chtimes sets the access and modification times of name without following symlinks.
+func (d *destDir) chtimes(name string, t time.Time) error {
+	if d.fd < 0 {
+		return os.Chtimes(d.join(name), t, t)
+	}
+	ts := unix.NsecToTimespec(t.UnixNano())
+	return d.pathErr("chtimes", name, unix.UtimesNanoAt(d.fd, name, []unix.Timespec{ts, ts}, unix.AT_SYMLINK_NOFOLLOW))
+}
@@ -0,0 +1,61 @@
+//go:build !linux
+
+package squashfs
+
+import (
+	"os"
+	"time"
+)
+
+//canMknod returns if an inode of the given type can be created. Only supported on Linux.
+func canMknod(uint16) bool {
+	return false
+}
+
+//openBeneath uses openat2 to make sure dir doesn't resolve to outside of root. Only available on Linux, so ok is always false.
+func openBeneath(*os.File, string) (fd int, ok bool, err error) {
+	return -1, false, nil
+}
+
+func (d *destDir) close() {}
+
+func (d *destDir) lstat(name string) (dir bool, modTime time.Time, err error) {
+	return lstatPath(d.join(name))
+}
+
+func (d *destDir) create(name string, perm os.FileMode) (*os.File, error) {
+	return os.OpenFile(d.join(name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+}
+
+func (d *destDir) mkdir(name string, perm os.FileMode) error {
+	return os.Mkdir(d.join(name), perm)
+}
+
+func (d *destDir) symlink(target, name string) error {
+	return os.Symlink(target, d.join(name))
+}
+
+func (d *destDir) link(old *destDir, oldName, name string) error {
+	return os.Link(old.join(oldName), d.join(name))
+}
+
+func (d *destDir) remove(name string) error {
+	return os.Remove(d.join(name))
+}
+
+//mknod creates a device, fifo, or socket. Only supported on Linux.
+func (d *destDir) mknod(string, uint16, uint32, uint32) error {
+	return ErrMknod
+}
+
+func (d *destDir) lchown(name string, uid, gid int) error {
+	return os.Lchown(d.join(name), uid, gid)
+}
+
+func (d *destDir) chmod(name string, perm uint16) error {
+	return os.Chmod(d.join(name), permMode(perm))
+}
+
+func (d *destDir) chtimes(name string, t time.Time) error {
+	return os.Chtimes(d.join(name), t, t)
+}
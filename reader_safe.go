@@ -0,0 +1,139 @@
+package squashfs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//validName returns if name can be used as a single path element. Names from the archive are otherwise joined to the extraction path as is.
+func validName(name string) bool {
+	if name == "" || name == "." || name == ".." {
+		return false
+	}
+	return !strings.ContainsAny(name, "/\x00") && !strings.ContainsRune(name, filepath.Separator)
+}
+
+//unsafePath returns the error for trying to extract to path, outside of the extraction folder.
+func unsafePath(path string) error {
+	return &fs.PathError{
+		Op:   "extract",
+		Path: path,
+		Err:  ErrUnsafePath,
+	}
+}
+
+//local returns if the relative path rel stays inside the folder it's relative to.
+func local(rel string) bool {
+	return !filepath.IsAbs(rel) && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+//openRoot gets the extraction folder ready for openDir. The folder must already exist. closeRoot must be called once done.
+func (s *extractState) openRoot() (err error) {
+	s.realRoot, err = filepath.Abs(s.root)
+	if err != nil {
+		return
+	}
+	s.realRoot, err = filepath.EvalSymlinks(s.realRoot)
+	if err != nil {
+		return
+	}
+	s.rootDir, err = os.Open(s.root)
+	return
+}
+
+func (s *extractState) closeRoot() {
+	if s.rootDir != nil {
+		s.rootDir.Close()
+	}
+}
+
+//destDir is a folder inside of the extraction folder that things are created in.
+//On Linux, it's opened with openat2's RESOLVE_BENEATH and everything is created relative to it, so nothing changed after it's opened,
+//such as a parent folder replaced by a symlink, can move where things are created. Elsewhere, or if openat2 isn't available,
+//it's symlinks are resolved and checked when it's opened, and it's then used by path.
+type destDir struct {
+	path string
+	fd   int //-1 if the folder is used by path
+}
+
+func (d *destDir) join(name string) string {
+	return filepath.Join(d.path, name)
+}
+
+//openDir opens folder so things can be created in it. Returns ErrUnsafePath if folder is outside of the extraction folder,
+//or if one of it's parents is a symlink that resolves to outside of it. close must be called once done.
+func (s *extractState) openDir(folder string) (*destDir, error) {
+	rel, err := filepath.Rel(s.root, folder)
+	if err != nil || !local(rel) {
+		return nil, unsafePath(folder)
+	}
+	fd, ok, err := openBeneath(s.rootDir, rel)
+	if !ok {
+		fd = -1
+		err = s.evalBeneath(rel)
+	}
+	if err == ErrUnsafePath {
+		return nil, unsafePath(folder)
+	} else if err != nil {
+		return nil, err
+	}
+	return &destDir{path: folder, fd: fd}, nil
+}
+
+//evalBeneath resolves the symlinks of dir, which is relative to the extraction folder, and makes sure it's still inside the extraction folder.
+func (s *extractState) evalBeneath(dir string) error {
+	real, err := filepath.Abs(filepath.Join(s.root, dir))
+	if err != nil {
+		return err
+	}
+	real, err = filepath.EvalSymlinks(real)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(s.realRoot, real)
+	if err != nil || !local(rel) {
+		return ErrUnsafePath
+	}
+	return nil
+}
+
+//mkdirBeneath creates folder, and any missing parents, inside of the extraction folder.
+//Existing parents that are symlinks are only followed if they resolve to inside the extraction folder.
+func (s *extractState) mkdirBeneath(folder string, perm fs.FileMode) error {
+	d, err := s.openDir(folder)
+	if err == nil {
+		d.close()
+		return nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	parent := filepath.Dir(folder)
+	err = s.mkdirBeneath(parent, perm)
+	if err != nil {
+		return err
+	}
+	d, err = s.openDir(parent)
+	if err != nil {
+		return err
+	}
+	defer d.close()
+	err = d.mkdir(filepath.Base(folder), perm)
+	//Something else created it first. Anything created in it is still checked by openDir.
+	if errors.Is(err, fs.ErrExist) {
+		return nil
+	}
+	return err
+}
+
+//lstatPath returns if path is a directory, and it's modification time, without following symlinks.
+func lstatPath(path string) (dir bool, modTime time.Time, err error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return false, time.Time{}, err
+	}
+	return info.IsDir(), info.ModTime(), nil
+}
@@ -0,0 +1,97 @@
+package squashfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+
+	"github.com/CalebQ42/squashfs/internal/inode"
+	"github.com/CalebQ42/squashfs/internal/metadata"
+	"github.com/CalebQ42/squashfs/internal/toreader"
+	"github.com/CalebQ42/squashfs/internal/xattr"
+)
+
+func (r *Reader) initXattr() (err error) {
+	var table xattr.IDTable
+	err = binary.Read(toreader.NewReader(r.r, int64(r.s.XattrTableStart)), binary.LittleEndian, &table)
+	if err != nil {
+		return
+	}
+	if !r.s.fits(uint64(table.Count), 16) {
+		return ErrorTable
+	}
+	offsets := make([]uint64, int(math.Ceil(float64(table.Count)/512)))
+	err = binary.Read(toreader.NewReader(r.r, int64(r.s.XattrTableStart)+16), binary.LittleEndian, &offsets)
+	if err != nil {
+		return
+	}
+	r.xattrStart = table.Start
+	r.xattrIDs = make([]xattr.ID, 0, table.Count)
+	left := table.Count
+	var toRead uint32
+	var new []xattr.ID
+	var rdr *metadata.Reader
+	for i := range offsets {
+		rdr = metadata.NewReader(r.r, offsets[i], 0, r.d, nil)
+		toRead = uint32(math.Min(512, float64(left)))
+		new = make([]xattr.ID, toRead)
+		err = binary.Read(rdr, binary.LittleEndian, &new)
+		if err != nil {
+			return
+		}
+		left -= toRead
+		r.xattrIDs = append(r.xattrIDs, new...)
+	}
+	return nil
+}
+
+//xattrInd returns the inode's index in the xattr id table. If the inode doesn't have one, returns 0xFFFFFFFF.
+func xattrInd(i inode.Inode) uint32 {
+	switch d := i.Data.(type) {
+	case inode.EFile:
+		return d.XattrInd
+	case inode.EDirectory:
+		return d.XattrInd
+	case inode.ESymlink:
+		return d.XattrInd
+	case inode.EDevice:
+		return d.XattrInd
+	case inode.EIPC:
+		return d.XattrInd
+	}
+	return 0xFFFFFFFF
+}
+
+//xattrValue reads an out of line xattr value.
+func (r Reader) xattrValue(ref uint64) ([]byte, error) {
+	rdr := metadata.NewReader(r.r, r.xattrStart+(ref>>16), uint16(ref), r.d, r.cache)
+	return xattr.ReadValue(rdr)
+}
+
+//xattrs returns the extended attributes of the inode. If the inode has none, returns nil.
+func (r Reader) xattrs(i inode.Inode) (map[string][]byte, error) {
+	ind := xattrInd(i)
+	if ind == 0xFFFFFFFF {
+		return nil, nil
+	}
+	if int(ind) >= len(r.xattrIDs) {
+		return nil, errors.New("xattr index out of range")
+	}
+	id := r.xattrIDs[ind]
+	rdr := metadata.NewReader(r.r, r.xattrStart+(id.Ref>>16), uint16(id.Ref), r.d, r.cache)
+	pairs, err := xattr.Read(rdr, id.Count)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string][]byte, len(pairs))
+	for _, p := range pairs {
+		if p.OOL {
+			p.Value, err = r.xattrValue(p.Ref)
+			if err != nil {
+				return nil, err
+			}
+		}
+		out[p.Name] = p.Value
+	}
+	return out, nil
+}
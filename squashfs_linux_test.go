@@ -0,0 +1,201 @@
+package squashfs_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"testing/fstest"
+
+	"github.com/CalebQ42/squashfs"
+)
+
+func TestExtractSparse(t *testing.T) {
+	//A file that starts and ends with data, and one that ends in a hole.
+	disk := make([]byte, 32*1024*1024+100)
+	copy(disk, "boot sector")
+	copy(disk[len(disk)-10:], "end")
+	trailing := make([]byte, 16*1024*1024)
+	copy(trailing, "data")
+	src := fstest.MapFS{
+		"disk.img":     {Data: disk, Mode: 0644},
+		"trailing.img": {Data: trailing, Mode: 0644},
+	}
+	rdr := buildArchive(t, src)
+	dir := t.TempDir()
+	err := rdr.ExtractTo(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string][]byte{"disk.img": disk, "trailing.img": trailing} {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: got %d bytes, want %d", name, len(got), len(want))
+		}
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if used := info.Sys().(*syscall.Stat_t).Blocks * 512; used >= info.Size()/2 {
+			t.Errorf("%s: uses %d bytes on disk for a %d byte file, holes weren't skipped", name, used, info.Size())
+		}
+		fil, err := rdr.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, _ = fil.Stat()
+		if sparse := info.Sys().(*squashfs.InodeInfo).Sparse; sparse == 0 {
+			t.Errorf("%s: no sparse bytes reported", name)
+		}
+		var read bytes.Buffer
+		_, err = read.ReadFrom(fil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(read.Bytes(), want) {
+			t.Errorf("%s: read %d bytes, want %d", name, read.Len(), len(want))
+		}
+	}
+}
+
+func TestExtractOwnership(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("ownership can only be changed as root")
+	}
+	src := t.TempDir()
+	err := os.WriteFile(filepath.Join(src, "file"), []byte("owned"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Symlink("file", filepath.Join(src, "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"file", "link"} {
+		err = os.Lchown(filepath.Join(src, name), 1234, 5678)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	rdr := readArchive(t, writeArchive(t, squashfs.NewWriterFromPath(src)))
+	for _, ownership := range []squashfs.Ownership{squashfs.OwnershipAuto, squashfs.OwnershipNever} {
+		dir := t.TempDir()
+		op := squashfs.DefaultOptions()
+		op.Ownership = ownership
+		_, err = rdr.ExtractWithOptions(dir, op)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"file", "link"} {
+			info, err := os.Lstat(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			st := info.Sys().(*syscall.Stat_t)
+			owned := st.Uid == 1234 && st.Gid == 5678
+			if owned != (ownership == squashfs.OwnershipAuto) {
+				t.Errorf("ownership %d: %s is owned by %d:%d", ownership, name, st.Uid, st.Gid)
+			}
+		}
+	}
+}
+
+//renamingZlib is a Decompressor for gzip compressed archives that replaces old with new in everything it decompresses.
+//Used to make archives with names the Writer won't create.
+type renamingZlib struct {
+	old, new string
+}
+
+func (r renamingZlib) Reader(src io.Reader) (io.ReadCloser, error) {
+	rdr, err := zlib.NewReader(src)
+	if err != nil {
+		return nil, err
+	}
+	dat, err := io.ReadAll(rdr)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(bytes.ReplaceAll(dat, []byte(r.old), []byte(r.new)))), nil
+}
+
+func (renamingZlib) Resetable() bool { return false }
+
+func (renamingZlib) Reset(io.Reader, io.Reader) error { return errors.New("not resetable") }
+
+func TestExtractUnsafe(t *testing.T) {
+	outside := t.TempDir()
+	src := t.TempDir()
+	err := os.Mkdir(filepath.Join(src, "dirAA"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"@@", "dirAA/file"} {
+		err = os.WriteFile(filepath.Join(src, name), []byte("archive"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = os.Symlink(outside, filepath.Join(src, "dirBB"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := writeArchive(t, squashfs.NewWriterFromPath(src))
+	tests := []struct {
+		name     string
+		old, new string
+	}{
+		{"dot dot", "@@", ".."},
+		//The symlink would replace the directory, so the directory's contents are written to outside.
+		{"duplicate", "dirBB", "dirAA"},
+	}
+	for _, test := range tests {
+		op := squashfs.DefaultReaderOptions()
+		op.Decompressor = renamingZlib{test.old, test.new}
+		rdr, err := squashfs.NewReaderWithOptions(bytes.NewReader(archive), op)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		err = rdr.ExtractTo(filepath.Join(t.TempDir(), "dest"))
+		if !errors.Is(err, squashfs.ErrUnsafePath) {
+			t.Errorf("%s: got error %v, want %v", test.name, err, squashfs.ErrUnsafePath)
+		}
+	}
+	//Symlinks already in the extraction folder are replaced, not written through.
+	rdr := readArchive(t, archive)
+	victim := filepath.Join(outside, "victim")
+	err = os.WriteFile(victim, []byte("original"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := t.TempDir()
+	err = os.Symlink(victim, filepath.Join(dest, "@@"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rdr.ExtractTo(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dat, err := os.ReadFile(filepath.Join(dest, "@@"))
+	if err != nil || string(dat) != "archive" {
+		t.Errorf("got %q, %v from the extracted file", dat, err)
+	}
+	ents, err := os.ReadDir(outside)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ents) != 1 {
+		t.Errorf("%d files outside of the extraction folder, want 1", len(ents))
+	}
+	dat, err = os.ReadFile(victim)
+	if err != nil || string(dat) != "original" {
+		t.Errorf("got %q, %v from the file outside of the extraction folder", dat, err)
+	}
+}
@@ -3,15 +3,26 @@ package squashfs_test
 //Actually proper tests go here.
 
 import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
 	"errors"
 	"io"
 	"io/fs"
+	"math/rand"
 	"net/http"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"reflect"
+	"runtime"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"testing/fstest"
 	"time"
 
 	"github.com/CalebQ42/squashfs"
@@ -109,7 +120,7 @@ func TestExtractQuick(t *testing.T) {
 	}
 	op := squashfs.DefaultOptions()
 	op.Verbose = true
-	err = rdr.ExtractWithOptions(libPath, op)
+	_, err = rdr.ExtractWithOptions(libPath, op)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -143,3 +154,1452 @@ func TestExtractQuick(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+type xattrFS struct {
+	fstest.MapFS
+	xattrs map[string]map[string][]byte
+}
+
+func (x xattrFS) Xattrs(name string) (map[string][]byte, error) {
+	return x.xattrs[name], nil
+}
+
+func TestXattrs(t *testing.T) {
+	label := []byte("system_u:object_r:bin_t:s0")
+	src := xattrFS{
+		MapFS: fstest.MapFS{
+			"bin/ping": {Data: []byte("ping"), Mode: 0755},
+			"bin/ls":   {Data: []byte("ls"), Mode: 0755},
+			"plain":    {Data: []byte("plain"), Mode: 0644},
+		},
+		xattrs: map[string]map[string][]byte{
+			"bin": {"security.selinux": label},
+			"bin/ping": {
+				"security.selinux":    label,
+				"security.capability": {1, 0, 0, 2, 0, 0x20},
+				"user.comment":        []byte("pings things"),
+				"system.unsupported":  []byte("dropped"),
+			},
+			"bin/ls": {"security.selinux": label},
+		},
+	}
+	rdr := buildArchive(t, src)
+	for _, name := range []string{"bin", "bin/ping", "bin/ls", "plain"} {
+		want := src.xattrs[name]
+		delete(want, "system.unsupported")
+		fil, err := rdr.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := fil.(*squashfs.File).Xattrs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("%s: got %d xattrs, want %d", name, len(got), len(want))
+		}
+		for k, v := range want {
+			if !bytes.Equal(got[k], v) {
+				t.Errorf("%s: xattr %s is %q, want %q", name, k, got[k], v)
+			}
+		}
+		got, err = rdr.Xattrs(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(want) {
+			t.Errorf("%s: FS.Xattrs has %d xattrs, want %d", name, len(got), len(want))
+		}
+		info, err := rdr.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err = info.Sys().(*squashfs.InodeInfo).Xattrs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: Sys has xattrs %q, want %q", name, got, want)
+		}
+	}
+	//The xattrs are kept when the archive is used to make another.
+	rdr = buildArchive(t, rdr)
+	got, err := rdr.Xattrs("bin/ping")
+	if err != nil || len(got) != 3 {
+		t.Errorf("got %v, %v from the repacked archive, want 3 xattrs", got, err)
+	}
+}
+
+func TestCompressionOptions(t *testing.T) {
+	src := fstest.MapFS{
+		"file": {Data: bytes.Repeat([]byte("compress me "), 20000), Mode: 0644},
+	}
+	ops := []squashfs.CompressionOptions{
+		squashfs.GZipOptions{Level: 6, WindowSize: 15, Strategies: squashfs.GZipDefault},
+		squashfs.XzOptions{DictionarySize: 192 * 1024},
+		squashfs.Lz4Options{Version: 1, Flags: squashfs.Lz4HighCompression},
+		squashfs.ZstdOptions{Level: 3},
+		squashfs.LzoOptions{Algorithm: squashfs.Lzo1x999, Level: 9},
+	}
+	for _, op := range ops {
+		w := squashfs.NewWriter(src)
+		w.Compression = op.Compression()
+		w.CompressionOptions = op
+		rdr := readArchive(t, writeArchive(t, w))
+		if got := rdr.CompressionOptions(); got != op {
+			t.Errorf("got options %+v, want %+v", got, op)
+		}
+		dat, err := rdr.ReadFile("file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(dat, src["file"].Data) {
+			t.Errorf("%T: data differs", op)
+		}
+	}
+	//lz4 with a version other then 1 can't be read.
+	w := squashfs.NewWriter(src)
+	w.Compression = squashfs.LZ4Compression
+	w.CompressionOptions = squashfs.Lz4Options{Version: 1}
+	dat := writeArchive(t, w)
+	dat[98] = 2
+	_, err := squashfs.NewReader(bytes.NewReader(dat))
+	if err != squashfs.ErrCompressionOptions {
+		t.Fatalf("got error %v, want %v", err, squashfs.ErrCompressionOptions)
+	}
+}
+
+func TestSys(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("ownership and hard links are only archived on linux")
+	}
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "file"), []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Link(filepath.Join(dir, "file"), filepath.Join(dir, "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "sparse"), make([]byte, 256*1024), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdr := readArchive(t, writeArchive(t, squashfs.NewWriterFromPath(dir)))
+	sys := func(name string) *squashfs.InodeInfo {
+		info, err := rdr.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.Sys().(*squashfs.InodeInfo)
+	}
+	fil, link, sparse := sys("file"), sys("link"), sys("sparse")
+	if fil.Inode != link.Inode || fil.Nlink != 2 {
+		t.Errorf("hard link has inode %d with %d links, want inode %d with 2 links", link.Inode, fil.Nlink, fil.Inode)
+	}
+	if fil.Uid != uint32(os.Getuid()) || fil.Gid != uint32(os.Getgid()) {
+		t.Errorf("owned by %d:%d, want %d:%d", fil.Uid, fil.Gid, os.Getuid(), os.Getgid())
+	}
+	if fil.Type != squashfs.EFileType || fil.XattrIndex != 0xFFFFFFFF {
+		t.Errorf("file has type %d and xattr index %d", fil.Type, fil.XattrIndex)
+	}
+	if sparse.Type != squashfs.EFileType || sparse.Sparse != 256*1024 {
+		t.Errorf("sparse file has type %d with %d sparse bytes", sparse.Type, sparse.Sparse)
+	}
+	if root := sys("."); root.Type != squashfs.DirType || root.Nlink != 2 {
+		t.Errorf("root has type %d with %d links", root.Type, root.Nlink)
+	}
+}
+
+func TestFileMode(t *testing.T) {
+	src := fstest.MapFS{
+		"dir":    {Mode: fs.ModeDir | fs.ModeSticky | 0777},
+		"file":   {Data: []byte("file"), Mode: fs.ModeSetuid | fs.ModeSetgid | 0755},
+		"link":   {Data: []byte("file"), Mode: fs.ModeSymlink | 0777},
+		"block":  {Mode: fs.ModeDevice | 0660},
+		"char":   {Mode: fs.ModeDevice | fs.ModeCharDevice | 0666},
+		"fifo":   {Mode: fs.ModeNamedPipe | 0644},
+		"socket": {Mode: fs.ModeSocket | 0755},
+	}
+	rdr := buildArchive(t, src)
+	ents, err := rdr.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ents) != len(src) {
+		t.Fatalf("got %d entries, want %d", len(ents), len(src))
+	}
+	for _, ent := range ents {
+		want := src[ent.Name()].Mode
+		info, err := ent.Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode() != want {
+			t.Errorf("%s: mode %v, want %v", ent.Name(), info.Mode(), want)
+		}
+		if ent.Type() != want.Type() || info.IsDir() != want.IsDir() {
+			t.Errorf("%s: type %v, want %v", ent.Name(), ent.Type(), want.Type())
+		}
+	}
+}
+
+func TestExtractDevices(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("devices can only be extracted on linux")
+	}
+	src := fstest.MapFS{
+		"dev/null": {Mode: fs.ModeDevice | fs.ModeCharDevice | 0666},
+		"dev/sda":  {Mode: fs.ModeDevice | 0660},
+		"fifo":     {Mode: fs.ModeNamedPipe | 0644},
+		"sock":     {Mode: fs.ModeSocket | 0755},
+	}
+	rdr := buildArchive(t, src)
+	dir := t.TempDir()
+	op := squashfs.DefaultOptions()
+	op.DevicePolicy = squashfs.DevicePlaceholder
+	sum, err := rdr.ExtractWithOptions(dir, op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if os.Geteuid() != 0 && len(sum.Warnings) != 2 {
+		t.Errorf("got warnings %v, want one for each placeholder", sum.Warnings)
+	}
+	want := map[string]fs.FileMode{
+		"dev/null": fs.ModeDevice | fs.ModeCharDevice,
+		"dev/sda":  fs.ModeDevice,
+		"fifo":     fs.ModeNamedPipe,
+		"sock":     fs.ModeSocket,
+	}
+	if os.Geteuid() != 0 {
+		want["dev/null"] = 0
+		want["dev/sda"] = 0
+	}
+	types := map[fs.FileMode]int{fs.ModeDir: 1}
+	for name, typ := range want {
+		types[typ]++
+		info, err := os.Lstat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Type() != typ {
+			t.Errorf("%s: type %v, want %v", name, info.Mode().Type(), typ)
+		}
+	}
+	if !reflect.DeepEqual(sum.Types, types) {
+		t.Errorf("got types %v, want %v", sum.Types, types)
+	}
+}
+
+func TestExtractHardLinks(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("hard links are only archived on linux")
+	}
+	dir := t.TempDir()
+	err := os.Mkdir(filepath.Join(dir, "sub"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "file"), []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Link(filepath.Join(dir, "file"), filepath.Join(dir, "sub", "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdr := readArchive(t, writeArchive(t, squashfs.NewWriterFromPath(dir)))
+	for _, copyLinks := range []bool{false, true} {
+		out := t.TempDir()
+		op := squashfs.DefaultOptions()
+		op.CopyHardLinks = copyLinks
+		sum, err := rdr.ExtractWithOptions(out, op)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantLinks := 1
+		if copyLinks {
+			wantLinks = 0
+		}
+		if sum.Links != wantLinks || sum.Types[0] != 2-wantLinks {
+			t.Errorf("CopyHardLinks %v: summary has %d files and %d links, want %d and %d", copyLinks, sum.Types[0], sum.Links, 2-wantLinks, wantLinks)
+		}
+		fil, err := os.Stat(filepath.Join(out, "file"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		link, err := os.Stat(filepath.Join(out, "sub", "link"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if linked := os.SameFile(fil, link); linked == copyLinks {
+			t.Errorf("CopyHardLinks %v: files are linked: %v", copyLinks, linked)
+		}
+		dat, err := os.ReadFile(filepath.Join(out, "sub", "link"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(dat) != "data" {
+			t.Errorf("link has data %q, want data", dat)
+		}
+	}
+}
+
+func TestFileRandomAccess(t *testing.T) {
+	random := make([]byte, 300*1024)
+	rand.New(rand.NewSource(7)).Read(random)
+	sparse := make([]byte, 400*1024+10)
+	copy(sparse[200*1024:], random[:1000])
+	src := fstest.MapFS{
+		"random": {Data: random, Mode: 0644},
+		"sparse": {Data: sparse, Mode: 0644},
+	}
+	rdr := buildArchive(t, src)
+	for name, want := range map[string][]byte{"random": random, "sparse": sparse} {
+		f, err := rdr.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fil := f.(*squashfs.File)
+		for _, off := range []int64{0, 1, 128*1024 - 5, 200 * 1024, int64(len(want)) - 100} {
+			p := make([]byte, 64*1024)
+			n, err := fil.ReadAt(p, off)
+			end := off + int64(len(p))
+			if end > int64(len(want)) {
+				end = int64(len(want))
+				if err != io.EOF {
+					t.Errorf("%s: ReadAt(%d) past the end returned %v, want EOF", name, off, err)
+				}
+			} else if err != nil {
+				t.Fatalf("%s: ReadAt(%d): %v", name, off, err)
+			}
+			if !bytes.Equal(p[:n], want[off:end]) {
+				t.Errorf("%s: ReadAt(%d) returned the wrong data", name, off)
+			}
+		}
+		pos, err := fil.Seek(-3000, io.SeekEnd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dat, err := io.ReadAll(fil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(dat, want[pos:]) {
+			t.Errorf("%s: read %d bytes after seeking to %d, want %d", name, len(dat), pos, len(want[pos:]))
+		}
+		_, err = fil.Seek(150*1024, io.SeekStart)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dat = make([]byte, 100*1024)
+		_, err = io.ReadFull(fil, dat)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(dat, want[150*1024:250*1024]) {
+			t.Errorf("%s: wrong data after seeking", name)
+		}
+	}
+}
+
+func TestMetadataCache(t *testing.T) {
+	src := testFS()
+	dat := writeArchive(t, squashfs.NewWriter(src))
+	//Number of blocks decompressed while walking the archive, by cache size.
+	counts := make(map[int]int32)
+	for _, size := range []int{0, 2, 1024} {
+		var count int32
+		op := squashfs.DefaultReaderOptions()
+		op.MetadataCacheSize = size
+		op.Decompressor = countingZlib{&count}
+		rdr, err := squashfs.NewReaderWithOptions(bytes.NewReader(dat), op)
+		if err != nil {
+			t.Fatal(err)
+		}
+		//Walk the archive multiple times at once to make sure the cache is safe to use concurrently.
+		errs := make(chan error)
+		for i := 0; i < 2; i++ {
+			go func() {
+				errs <- fs.WalkDir(rdr, ".", func(path string, d fs.DirEntry, err error) error {
+					if err != nil || d.IsDir() {
+						return err
+					}
+					_, err = rdr.Stat(path)
+					return err
+				})
+			}()
+		}
+		for i := 0; i < 2; i++ {
+			if err = <-errs; err != nil {
+				t.Fatalf("cache size %d: %v", size, err)
+			}
+		}
+		counts[size] = atomic.LoadInt32(&count)
+		compareFS(t, src, rdr)
+	}
+	//The cache is large enough to hold every metadata block, so each is only decompressed once instead of for every lookup.
+	if counts[1024]*10 > counts[0] {
+		t.Errorf("decompressed %d blocks with a cache, and %d without", counts[1024], counts[0])
+	}
+}
+
+func TestDirectoryIndex(t *testing.T) {
+	src := fstest.MapFS{}
+	for i := 0; i < 5000; i++ {
+		src["big/file"+strconv.Itoa(i)] = &fstest.MapFile{Data: []byte(strconv.Itoa(i)), Mode: 0644}
+	}
+	src["big/zzz/last"] = &fstest.MapFile{Data: []byte("last"), Mode: 0644}
+	dat := writeArchive(t, squashfs.NewWriter(src))
+	rdr := readArchive(t, dat)
+	info, err := rdr.Stat("big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ := info.Sys().(*squashfs.InodeInfo).Type; typ != squashfs.EDirType {
+		t.Fatalf("big has type %d, want an extended directory with an index", typ)
+	}
+	for name, fil := range src {
+		dat, err := rdr.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(dat, fil.Data) {
+			t.Errorf("%s: got %q, want %q", name, dat, fil.Data)
+		}
+	}
+	for _, name := range []string{"big/a", "big/file", "big/file10000", "big/file4999a", "big/zzzz", "big/file1/x", "big/zzz/missing"} {
+		_, err = rdr.Stat(name)
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s: got error %v, want %v", name, err, fs.ErrNotExist)
+		}
+	}
+	ents, err := rdr.ReadDir("big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ents) != 5001 {
+		t.Errorf("got %d entries, want 5001", len(ents))
+	}
+	matches, err := rdr.Glob("b*/z*/l*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 || matches[0] != "big/zzz/last" {
+		t.Errorf("glob returned %v, want [big/zzz/last]", matches)
+	}
+	//With the index, a lookup only decompresses the directory blocks near the entry instead of all of them.
+	var count int32
+	op := squashfs.DefaultReaderOptions()
+	op.MetadataCacheSize = 0
+	op.Decompressor = countingZlib{&count}
+	rdr, err = squashfs.NewReaderWithOptions(bytes.NewReader(dat), op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decompressed := func(f func() error) int32 {
+		t.Helper()
+		atomic.StoreInt32(&count, 0)
+		if err := f(); err != nil {
+			t.Fatal(err)
+		}
+		return atomic.LoadInt32(&count)
+	}
+	first := decompressed(func() error { _, err := rdr.Stat("big/file0"); return err })
+	last := decompressed(func() error { _, err := rdr.Stat("big/file4999"); return err })
+	list := decompressed(func() error { _, err := rdr.ReadDir("big"); return err })
+	//file4999 is near the end of big, so without the index every block before it would be decompressed.
+	if last > first+1 || last*10 > list {
+		t.Errorf("looking up big/file4999 decompressed %d blocks, big/file0 %d, and reading all of big %d", last, first, list)
+	}
+}
+
+func TestNewReaderFromReader(t *testing.T) {
+	src := fstest.MapFS{
+		"file": {Data: bytes.Repeat([]byte("stream me "), 1000), Mode: 0644},
+	}
+	dat := writeArchive(t, squashfs.NewWriter(src))
+	check := func(name string, rdr *squashfs.Reader, err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got, err := rdr.ReadFile("file")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(got, src["file"].Data) {
+			t.Errorf("%s: data differs", name)
+		}
+	}
+	//An io.ReadSeeker is read from it's current position.
+	prefixed := append([]byte("prefix"), dat...)
+	seeker := struct{ io.ReadSeeker }{bytes.NewReader(prefixed)}
+	seeker.Seek(6, io.SeekStart)
+	rdr, err := squashfs.NewReaderFromReader(seeker)
+	check("seeker", rdr, err)
+	//Even if it's also an io.ReaderAt, such as an *os.File.
+	both := bytes.NewReader(prefixed)
+	both.Seek(6, io.SeekStart)
+	rdr, err = squashfs.NewReaderFromReader(both)
+	check("seeker and readerat", rdr, err)
+	//Streams are kept in memory until they pass MemoryThreshold, then are spooled to TempDir.
+	//The temporary file is removed right away, except on Windows where it's removed by Close.
+	dir := t.TempDir()
+	op := squashfs.DefaultReaderOptions()
+	op.TempDir = dir
+	for _, threshold := range []int64{int64(len(dat)), 1024} {
+		op.MemoryThreshold = threshold
+		rdr, err = squashfs.NewReaderFromReaderWithOptions(struct{ io.Reader }{bytes.NewReader(dat)}, op)
+		check("stream", rdr, err)
+		spooled, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := threshold < int64(len(dat)) && runtime.GOOS == "windows"; (len(spooled) == 1) != want {
+			t.Errorf("threshold %d: %d temporary files before Close", threshold, len(spooled))
+		}
+		err = rdr.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		spooled, err = os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(spooled) != 0 {
+			t.Errorf("threshold %d: temporary file not removed after Close", threshold)
+		}
+	}
+	//Only streams past MemoryThreshold need TempDir.
+	op.TempDir = filepath.Join(dir, "missing")
+	_, err = squashfs.NewReaderFromReaderWithOptions(struct{ io.Reader }{bytes.NewReader(dat)}, op)
+	if err == nil {
+		t.Error("a stream past MemoryThreshold was read without spooling it to TempDir")
+	}
+}
+
+func TestProbe(t *testing.T) {
+	w := squashfs.NewWriter(testFS())
+	w.BlockSize = 64 * 1024
+	w.ModTime = time.Unix(1600000000, 0)
+	w.Compression = squashfs.ZSTDCompression
+	w.CompressionOptions = squashfs.ZstdOptions{Level: 5}
+	dat := writeArchive(t, w)
+	info, err := squashfs.Probe(bytes.NewReader(dat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.BlockSize != w.BlockSize || info.Compression != w.Compression || info.CompressionOptions != w.CompressionOptions {
+		t.Errorf("got block size %d and compression %d %+v", info.BlockSize, info.Compression, info.CompressionOptions)
+	}
+	if !info.ModTime.Equal(w.ModTime) {
+		t.Errorf("got mod time %v, want %v", info.ModTime, w.ModTime)
+	}
+	if !info.Exportable || info.NoFragments || !info.HasCompressionOptions || info.Size > uint64(len(dat)) {
+		t.Errorf("unexpected info: %+v", info)
+	}
+	rdr := readArchive(t, dat)
+	if rdr.Info() != info {
+		t.Errorf("Reader.Info() returned %+v, want %+v", rdr.Info(), info)
+	}
+	_, err = squashfs.Probe(bytes.NewReader(make([]byte, 4096)))
+	if err != squashfs.ErrorMagic {
+		t.Errorf("got error %v, want %v", err, squashfs.ErrorMagic)
+	}
+}
+
+//countingZlib is a Decompressor for gzip compressed archives that counts how many blocks it decompresses.
+type countingZlib struct {
+	count *int32
+}
+
+func (c countingZlib) Reader(src io.Reader) (io.ReadCloser, error) {
+	atomic.AddInt32(c.count, 1)
+	return zlib.NewReader(src)
+}
+
+func (countingZlib) Resetable() bool { return false }
+
+func (countingZlib) Reset(io.Reader, io.Reader) error { return errors.New("not resetable") }
+
+func TestDecompressor(t *testing.T) {
+	src := fstest.MapFS{
+		"file": {Data: bytes.Repeat([]byte("decompress me "), 20000), Mode: 0644},
+	}
+	archive := writeArchive(t, squashfs.NewWriter(src))
+	check := func(name string, rdr *squashfs.Reader, err error, count *int32) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		dat, err := rdr.ReadFile("file")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(dat, src["file"].Data) {
+			t.Errorf("%s: data differs", name)
+		}
+		if atomic.LoadInt32(count) == 0 {
+			t.Errorf("%s: custom decompressor wasn't used", name)
+		}
+	}
+	var count int32
+	op := squashfs.DefaultReaderOptions()
+	op.Decompressor = countingZlib{&count}
+	rdr, err := squashfs.NewReaderWithOptions(bytes.NewReader(archive), op)
+	check("option", rdr, err, &count)
+	//Pretend the archive uses a vendor specific compression type.
+	const vendorCompression = 0x4242
+	dat := append([]byte{}, archive...)
+	binary.LittleEndian.PutUint16(dat[20:], vendorCompression)
+	_, err = squashfs.NewReader(bytes.NewReader(dat))
+	if err != squashfs.ErrCompression {
+		t.Fatalf("got error %v, want %v", err, squashfs.ErrCompression)
+	}
+	count = 0
+	squashfs.RegisterDecompressor(vendorCompression, countingZlib{&count})
+	defer squashfs.RegisterDecompressor(vendorCompression, nil)
+	rdr, err = squashfs.NewReader(bytes.NewReader(dat))
+	check("registered", rdr, err, &count)
+}
+
+//endlessZlib is a Decompressor for gzip compressed archives that makes every full data block decompress to an endless stream of zeros.
+type endlessZlib struct{}
+
+func (endlessZlib) Reader(src io.Reader) (io.ReadCloser, error) {
+	rdr, err := zlib.NewReader(src)
+	if err != nil {
+		return nil, err
+	}
+	dat, err := io.ReadAll(rdr)
+	if err != nil {
+		return nil, err
+	}
+	if len(dat) < 128*1024 {
+		return io.NopCloser(bytes.NewReader(dat)), nil
+	}
+	return io.NopCloser(io.MultiReader(bytes.NewReader(dat), zeroReader{})), nil
+}
+
+func (endlessZlib) Resetable() bool { return false }
+
+func (endlessZlib) Reset(io.Reader, io.Reader) error { return errors.New("not resetable") }
+
+//zeroReader reads zeros forever.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func TestCheck(t *testing.T) {
+	src := fstest.MapFS{
+		"dir/file": {Data: bytes.Repeat([]byte("check me "), 30000), Mode: 0644},
+		"small":    {Data: []byte("fragment"), Mode: 0644},
+	}
+	archive := writeArchive(t, squashfs.NewWriter(src))
+	check := func(dat []byte) []squashfs.Finding {
+		t.Helper()
+		findings, err := readArchive(t, dat).Check(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return findings
+	}
+	if findings := check(archive); len(findings) != 0 {
+		t.Fatalf("clean archive has problems: %v", findings)
+	}
+	//Without any ids, every inode's uid and gid are out of range. All of them should be reported.
+	dat := append([]byte{}, archive...)
+	binary.LittleEndian.PutUint16(dat[26:], 0)
+	if findings := check(dat); len(findings) != 8 {
+		t.Errorf("got %d findings for missing ids, want 8: %v", len(findings), findings)
+	}
+	//The first data block is right after the superblock.
+	dat = append([]byte{}, archive...)
+	copy(dat[96:], []byte{0xff, 0xff, 0xff, 0xff})
+	findings := check(dat)
+	if len(findings) != 1 || findings[0].Offset != 96 || findings[0].Path != "dir/file" {
+		t.Errorf("got %v for a corrupt data block, want one finding at offset 96", findings)
+	}
+	//Blocks that decompress to more then the block size are reported without decompressing all of them.
+	op := squashfs.DefaultReaderOptions()
+	op.Decompressor = endlessZlib{}
+	rdr, err := squashfs.NewReaderWithOptions(bytes.NewReader(archive), op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	findings, err = rdr.Check(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) == 0 || findings[0].Path != "dir/file" {
+		t.Errorf("got %v for endless data blocks, want findings for dir/file", findings)
+	}
+	rdr = readArchive(t, archive)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = rdr.Check(ctx)
+	if err != context.Canceled {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+	//A broken id table stops NewReader, but CheckArchive reports it as a Finding.
+	dat = append([]byte{}, archive...)
+	info := rdr.Info()
+	binary.LittleEndian.PutUint64(dat[info.IdTableStart:], info.Size+1000)
+	_, err = squashfs.NewReader(bytes.NewReader(dat))
+	if err == nil {
+		t.Fatal("NewReader succeeded with a broken id table")
+	}
+	findings, err = squashfs.CheckArchive(context.Background(), bytes.NewReader(dat), squashfs.DefaultReaderOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 1 || findings[0].Offset != info.IdTableStart {
+		t.Errorf("got %v for a broken id table, want one finding at offset %d", findings, info.IdTableStart)
+	}
+}
+
+func TestExtractProgress(t *testing.T) {
+	src := fstest.MapFS{
+		"big":       {Data: bytes.Repeat([]byte("progress "), 100000), Mode: 0644},
+		"dir/small": {Data: []byte("small"), Mode: 0644},
+		"dir/empty": {Mode: 0644},
+		"link":      {Data: []byte("big"), Mode: fs.ModeSymlink | 0777},
+	}
+	rdr := buildArchive(t, src)
+	want := int64(len(src["big"].Data) + len(src["dir/small"].Data))
+	started := make(map[string]bool)
+	finished := make(map[string]bool)
+	var last squashfs.ExtractionProgress
+	op := squashfs.DefaultOptions()
+	op.Progress = func(p squashfs.ExtractionProgress) {
+		if p.TotalSize != want {
+			t.Errorf("%s: total size %d, want %d", p.Path, p.TotalSize, want)
+		}
+		if p.TotalWritten < last.TotalWritten {
+			t.Errorf("%s: total written went from %d to %d", p.Path, last.TotalWritten, p.TotalWritten)
+		}
+		if p.Done {
+			if !started[p.Path] || p.Err != nil || p.Written != p.Size {
+				t.Errorf("%s: finished with %+v", p.Path, p)
+			}
+			finished[p.Path] = true
+		} else if p.Written == 0 {
+			started[p.Path] = true
+		}
+		last = p
+	}
+	sum, err := rdr.ExtractWithOptions(t.TempDir(), op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"big", "dir/small", "dir/empty", "link"} {
+		if !finished[name] {
+			t.Errorf("%s wasn't reported as finished", name)
+		}
+	}
+	if last.TotalWritten != want {
+		t.Errorf("wrote %d bytes in total, want %d", last.TotalWritten, want)
+	}
+	//All of the files, plus dir.
+	if sum.Files != 5 || sum.Bytes != want || len(sum.Skipped) != 0 || len(sum.Warnings) != 0 || sum.Duration <= 0 {
+		t.Errorf("unexpected summary: %+v", sum)
+	}
+}
+
+func TestExtractAttributes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix permissions can't be applied on windows")
+	}
+	modTime := time.Unix(1500000000, 0)
+	src := fstest.MapFS{
+		"readonly":          {Mode: fs.ModeDir | 0555, ModTime: modTime},
+		"readonly/file":     {Data: []byte("private"), Mode: 0640, ModTime: modTime},
+		"readonly/setuid":   {Data: []byte("setuid"), Mode: fs.ModeSetuid | 0755, ModTime: modTime},
+		"readonly/link":     {Data: []byte("file"), Mode: fs.ModeSymlink | 0777, ModTime: modTime},
+		"readonly/sub":      {Mode: fs.ModeDir | 0700, ModTime: modTime},
+		"readonly/sub/file": {Data: []byte("deep"), Mode: 0600, ModTime: modTime},
+	}
+	rdr := buildArchive(t, src)
+	dir := t.TempDir()
+	//Make sure the read-only directory can be cleaned up.
+	defer os.Chmod(filepath.Join(dir, "restored", "readonly"), 0755)
+	defer os.Chmod(filepath.Join(dir, "ignored", "readonly"), 0755)
+	_, err := rdr.ExtractWithOptions(filepath.Join(dir, "restored"), squashfs.DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range src {
+		info, err := os.Lstat(filepath.Join(dir, "restored", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want.Mode.Type() == fs.ModeSymlink {
+			continue
+		}
+		if info.Mode() != want.Mode {
+			t.Errorf("%s: mode %v, want %v", name, info.Mode(), want.Mode)
+		}
+		if !info.ModTime().Equal(modTime) {
+			t.Errorf("%s: modification time %v, want %v", name, info.ModTime(), modTime)
+		}
+	}
+	op := squashfs.DefaultOptions()
+	op.IgnorePerm = true
+	op.IgnoreModTime = true
+	_, err = rdr.ExtractWithOptions(filepath.Join(dir, "ignored"), op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(dir, "ignored", "readonly", "setuid"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&fs.ModeSetuid != 0 || info.ModTime().Equal(modTime) {
+		t.Errorf("archive's mode %v or modification time %v was applied", info.Mode(), info.ModTime())
+	}
+}
+
+//cancelWriter cancels it's context on the first Write.
+type cancelWriter struct {
+	cancel context.CancelFunc
+}
+
+func (c cancelWriter) Write(p []byte) (int, error) {
+	c.cancel()
+	return len(p), nil
+}
+
+func TestContext(t *testing.T) {
+	src := fstest.MapFS{
+		"dir/file": {Data: bytes.Repeat([]byte("cancel me "), 200000), Mode: 0644},
+		"dir/two":  {Data: []byte("two"), Mode: 0644},
+	}
+	w := squashfs.NewWriter(src)
+	w.BlockSize = 4096
+	rdr := readArchive(t, writeArchive(t, w))
+	goroutines := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	fil, err := rdr.Open("dir/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = fil.(*squashfs.File).WriteToContext(ctx, cancelWriter{cancel})
+	if err != context.Canceled {
+		t.Errorf("WriteToContext returned %v, want %v", err, context.Canceled)
+	}
+	//Every block's goroutine should be finished once WriteToContext returns.
+	if n := runtime.NumGoroutine(); n > goroutines {
+		t.Errorf("%d goroutines left running", n-goroutines)
+	}
+	_, err = rdr.ReadFileContext(ctx, "dir/file")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("ReadFileContext returned %v, want %v", err, context.Canceled)
+	}
+	dir := t.TempDir()
+	err = rdr.ExtractContext(ctx, filepath.Join(dir, "out"))
+	if err != context.Canceled {
+		t.Errorf("ExtractContext returned %v, want %v", err, context.Canceled)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "out")); !os.IsNotExist(err) {
+		t.Errorf("extraction started after the context was canceled")
+	}
+	err = rdr.ExtractContext(context.Background(), filepath.Join(dir, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dat, err := os.ReadFile(filepath.Join(dir, "out", "dir", "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dat, src["dir/file"].Data) {
+		t.Error("extracted data differs")
+	}
+}
+
+func TestExtractWorkers(t *testing.T) {
+	src := fstest.MapFS{
+		"big": {Data: bytes.Repeat([]byte("workers "), 100000), Mode: 0644},
+	}
+	for i := 0; i < 100; i++ {
+		src["dir"+strconv.Itoa(i%5)+"/sub/file"+strconv.Itoa(i)] = &fstest.MapFile{Data: []byte(strconv.Itoa(i)), Mode: 0644}
+	}
+	w := squashfs.NewWriter(src)
+	w.BlockSize = 4096
+	rdr := readArchive(t, writeArchive(t, w))
+	for _, workers := range []int{1, 3} {
+		goroutines := runtime.NumGoroutine()
+		var most int
+		op := squashfs.DefaultOptions()
+		op.Workers = workers
+		op.Progress = func(squashfs.ExtractionProgress) {
+			if n := runtime.NumGoroutine() - goroutines; n > most {
+				most = n
+			}
+		}
+		dir := t.TempDir()
+		_, err := rdr.ExtractWithOptions(dir, op)
+		if err != nil {
+			t.Fatal(err)
+		}
+		//Goroutines can take a moment to exit after giving up their worker, so allow some leeway.
+		if most > 2*workers {
+			t.Errorf("%d workers: %d extra goroutines running at once", workers, most)
+		}
+		for name, fil := range src {
+			dat, err := os.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(dat, fil.Data) {
+				t.Errorf("%d workers: %s differs", workers, name)
+			}
+		}
+	}
+}
+
+func TestExtractFilter(t *testing.T) {
+	src := fstest.MapFS{
+		"usr/lib/a":       {Data: []byte("a"), Mode: 0644},
+		"usr/lib/sub/b":   {Data: []byte("b"), Mode: 0644},
+		"usr/lib/big":     {Data: []byte("too big"), Mode: 0644},
+		"usr/bin/c":       {Data: []byte("c"), Mode: 0644},
+		"etc/conf":        {Data: []byte("conf"), Mode: 0644},
+		"etc/cache.pyc":   {Data: []byte("pyc"), Mode: 0644},
+		"var/log/message": {Data: []byte("log"), Mode: 0644},
+	}
+	rdr := buildArchive(t, src)
+	dir := t.TempDir()
+	op := squashfs.DefaultOptions()
+	op.Include = []string{"usr/lib/**", "etc"}
+	op.Exclude = []string{"**/*.pyc"}
+	op.Filter = func(path string, info fs.FileInfo) bool {
+		return info.Size() < 4 || path == "etc/conf"
+	}
+	var filtered int64
+	op.Progress = func(p squashfs.ExtractionProgress) {
+		filtered = p.TotalSize
+	}
+	_, err := rdr.ExtractWithOptions(dir, op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{
+		"usr/lib/a":     true,
+		"usr/lib/sub/b": true,
+		"etc/conf":      true,
+	}
+	for name := range src {
+		_, err = os.Stat(filepath.Join(dir, name))
+		if exists := err == nil; exists != want[name] {
+			t.Errorf("%s: exists is %v", name, exists)
+		}
+	}
+	for _, name := range []string{"usr/bin", "var"} {
+		if _, err = os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s was created", name)
+		}
+	}
+	if filtered != 6 {
+		t.Errorf("total size is %d, want 6", filtered)
+	}
+	op = squashfs.DefaultOptions()
+	op.Include = []string{"usr/[lib"}
+	_, err = rdr.ExtractWithOptions(t.TempDir(), op)
+	if !errors.Is(err, path.ErrBadPattern) {
+		t.Errorf("got error %v, want %v", err, path.ErrBadPattern)
+	}
+}
+
+func TestExtractRewrite(t *testing.T) {
+	src := fstest.MapFS{
+		"squashfs-root/AppRun":          {Data: []byte("run"), Mode: 0755},
+		"squashfs-root/usr/bin/app":     {Data: []byte("app"), Mode: 0755},
+		"squashfs-root/usr/share/notes": {Data: []byte("notes"), Mode: 0644},
+		"top":                           {Data: []byte("top"), Mode: 0644},
+	}
+	rdr := buildArchive(t, src)
+	dir := t.TempDir()
+	op := squashfs.DefaultOptions()
+	op.StripComponents = 1
+	op.Transform = func(path string) string {
+		//share is created anyway, since notes is extracted into it.
+		if path == "usr" || path == "usr/share" {
+			return ""
+		}
+		return strings.TrimPrefix(path, "usr/")
+	}
+	_, err := rdr.ExtractWithOptions(dir, op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"AppRun":      "run",
+		"bin/app":     "app",
+		"share/notes": "notes",
+	}
+	for name, dat := range want {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != dat {
+			t.Errorf("%s: got %q, want %q", name, got, dat)
+		}
+	}
+	for _, name := range []string{"top", "squashfs-root", "usr"} {
+		if _, err = os.Lstat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s was extracted", name)
+		}
+	}
+	op.Transform = func(path string) string {
+		return "../" + path
+	}
+	_, err = rdr.ExtractWithOptions(filepath.Join(t.TempDir(), "dest"), op)
+	if !errors.Is(err, squashfs.ErrUnsafePath) {
+		t.Errorf("got error %v, want %v", err, squashfs.ErrUnsafePath)
+	}
+}
+
+func TestExtractConflict(t *testing.T) {
+	src := fstest.MapFS{
+		"file":        {Data: []byte("new"), Mode: 0644, ModTime: time.Unix(946684800, 0)},
+		"dir/inner":   {Data: []byte("inner"), Mode: 0644},
+		"link":        {Data: []byte("file"), Mode: fs.ModeSymlink | 0777},
+		"keep/inner2": {Data: []byte("inner2"), Mode: 0644},
+	}
+	rdr := buildArchive(t, src)
+	//existing fills dir with "old" files where file and dir are, an empty directory where link is, and a directory where keep is.
+	existing := func(dir string) {
+		for _, name := range []string{"file", "dir"} {
+			err := os.WriteFile(filepath.Join(dir, name), []byte("old"), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, name := range []string{"link", "keep"} {
+			err := os.Mkdir(filepath.Join(dir, name), 0755)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	//check makes sure each path in want is a file with the given contents, a directory if "/", or a symlink if "@".
+	check := func(policy squashfs.ConflictPolicy, dir string, want map[string]string) {
+		t.Helper()
+		for name, w := range want {
+			info, err := os.Lstat(filepath.Join(dir, name))
+			if err != nil {
+				t.Errorf("policy %d: %v", policy, err)
+				continue
+			}
+			switch w {
+			case "/":
+				if !info.IsDir() {
+					t.Errorf("policy %d: %s isn't a directory", policy, name)
+				}
+			case "@":
+				if info.Mode()&fs.ModeSymlink == 0 {
+					t.Errorf("policy %d: %s isn't a symlink", policy, name)
+				}
+			default:
+				dat, err := os.ReadFile(filepath.Join(dir, name))
+				if err != nil || string(dat) != w {
+					t.Errorf("policy %d: %s is %q, %v, want %q", policy, name, dat, err, w)
+				}
+			}
+		}
+	}
+	tests := []struct {
+		policy  squashfs.ConflictPolicy
+		want    map[string]string
+		skipped int
+	}{
+		{squashfs.ConflictOverwrite, map[string]string{"file": "new", "dir/inner": "inner", "link": "@", "keep/inner2": "inner2"}, 0},
+		{squashfs.ConflictSkip, map[string]string{"file": "old", "dir": "old", "link": "/", "keep/inner2": "inner2"}, 3},
+		//The existing files are newer then the archive's.
+		{squashfs.ConflictNewer, map[string]string{"file": "old", "dir": "old", "link": "/", "keep/inner2": "inner2"}, 3},
+		{squashfs.ConflictRename, map[string]string{"file": "old", "file.1": "new", "dir": "old", "dir.1/inner": "inner", "link": "/", "link.1": "@", "keep": "/", "keep.1/inner2": "inner2"}, 0},
+	}
+	for _, test := range tests {
+		dir := t.TempDir()
+		existing(dir)
+		op := squashfs.DefaultOptions()
+		op.OnConflict = test.policy
+		sum, err := rdr.ExtractWithOptions(dir, op)
+		if err != nil {
+			t.Fatalf("policy %d: %v", test.policy, err)
+		}
+		check(test.policy, dir, test.want)
+		if len(sum.Skipped) != test.skipped {
+			t.Errorf("policy %d: skipped %v", test.policy, sum.Skipped)
+		}
+	}
+	dir := t.TempDir()
+	existing(dir)
+	op := squashfs.DefaultOptions()
+	op.OnConflict = squashfs.ConflictError
+	_, err := rdr.ExtractWithOptions(dir, op)
+	if !errors.Is(err, fs.ErrExist) {
+		t.Errorf("got error %v, want %v", err, fs.ErrExist)
+	}
+	//Older files are replaced with ConflictNewer.
+	err = os.Chtimes(filepath.Join(dir, "file"), time.Unix(0, 0), time.Unix(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	op.OnConflict = squashfs.ConflictNewer
+	_, err = rdr.ExtractWithOptions(dir, op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(op.OnConflict, dir, map[string]string{"file": "new", "dir": "old"})
+}
+
+func TestExtractConflictLinks(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("hard links are only archived on linux")
+	}
+	src := t.TempDir()
+	err := os.Mkdir(filepath.Join(src, "sub"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(src, "file"), []byte("new"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Link(filepath.Join(src, "file"), filepath.Join(src, "sub", "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Symlink("file", filepath.Join(src, "sym"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdr := readArchive(t, writeArchive(t, squashfs.NewWriterFromPath(src)))
+	//Both paths of the hard link already exist. They're replaced, or renamed, and still linked.
+	for policy, names := range map[squashfs.ConflictPolicy][2]string{
+		squashfs.ConflictOverwrite: {"file", "sub/link"},
+		//The existing sub is renamed as well, so the link doesn't conflict.
+		squashfs.ConflictRename: {"file.1", "sub.1/link"},
+	} {
+		dir := t.TempDir()
+		err = os.Mkdir(filepath.Join(dir, "sub"), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"file", "sub/link"} {
+			err = os.WriteFile(filepath.Join(dir, name), []byte("old"), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		op := squashfs.DefaultOptions()
+		op.OnConflict = policy
+		sum, err := rdr.ExtractWithOptions(dir, op)
+		if err != nil {
+			t.Fatalf("policy %d: %v", policy, err)
+		}
+		if len(sum.Warnings) != 0 {
+			t.Errorf("policy %d: warnings %v", policy, sum.Warnings)
+		}
+		fil, err := os.Stat(filepath.Join(dir, names[0]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		link, err := os.Stat(filepath.Join(dir, names[1]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !os.SameFile(fil, link) {
+			t.Errorf("policy %d: %s and %s aren't linked", policy, names[0], names[1])
+		}
+	}
+	//A symlink's target is only extracted once, even though UnbreakSymlink extracts it again.
+	for _, policy := range []squashfs.ConflictPolicy{squashfs.ConflictError, squashfs.ConflictSkip} {
+		op := squashfs.DefaultOptions()
+		op.OnConflict = policy
+		op.UnbreakSymlink = true
+		sum, err := rdr.ExtractWithOptions(t.TempDir(), op)
+		if err != nil {
+			t.Errorf("policy %d: %v", policy, err)
+		}
+		if len(sum.Skipped) != 0 {
+			t.Errorf("policy %d: skipped %v", policy, sum.Skipped)
+		}
+	}
+	//Directories that aren't empty aren't replaced.
+	dir := t.TempDir()
+	err = os.MkdirAll(filepath.Join(dir, "file", "inner"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = rdr.ExtractWithOptions(dir, squashfs.DefaultOptions())
+	var pathErr *fs.PathError
+	if !errors.Is(err, squashfs.ErrNotEmpty) || !errors.As(err, &pathErr) || pathErr.Path != filepath.Join(dir, "file") {
+		t.Errorf("got error %v, want %v for %s", err, squashfs.ErrNotEmpty, filepath.Join(dir, "file"))
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	archive := writeArchive(t, squashfs.NewWriter(testFS()))
+	tests := []struct {
+		name    string
+		corrupt func(dat []byte) []byte
+		want    error
+	}{
+		{"truncated", func(dat []byte) []byte { return dat[:len(dat)/2] }, squashfs.ErrorTruncated},
+		{"block size", func(dat []byte) []byte {
+			binary.LittleEndian.PutUint32(dat[12:], 3)
+			return dat
+		}, squashfs.ErrorLog},
+		{"fragment count", func(dat []byte) []byte {
+			binary.LittleEndian.PutUint32(dat[16:], 0xFFFFFFFF)
+			return dat
+		}, squashfs.ErrorTable},
+	}
+	for _, test := range tests {
+		dat := test.corrupt(append([]byte{}, archive...))
+		_, err := squashfs.NewReader(bytes.NewReader(dat))
+		if !errors.Is(err, test.want) {
+			t.Errorf("%s: got error %v, want %v", test.name, err, test.want)
+		}
+	}
+}
+
+func FuzzReader(f *testing.F) {
+	src := fstest.MapFS{
+		"dir/file":    {Data: bytes.Repeat([]byte("fuzz me "), 3000), Mode: 0644},
+		"dir/small":   {Data: []byte("fragment"), Mode: 0644},
+		"link":        {Data: []byte("dir/file"), Mode: fs.ModeSymlink | 0777},
+		"dir/sub/dev": {Mode: fs.ModeDevice | 0600},
+	}
+	w := squashfs.NewWriter(src)
+	w.BlockSize = 4096
+	f.Add(writeArchive(f, w))
+	f.Fuzz(func(t *testing.T, dat []byte) {
+		rdr, err := squashfs.NewReader(bytes.NewReader(dat))
+		if err != nil {
+			return
+		}
+		rdr.Check(context.Background())
+		fs.WalkDir(rdr, ".", func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			fil, err := rdr.Open(path)
+			if err != nil {
+				return nil
+			}
+			//Sparse files can legitimately be huge, so only read the start.
+			io.Copy(io.Discard, io.LimitReader(fil, 1024*1024))
+			fil.Close()
+			return nil
+		})
+	})
+}
+
+//openMksquashfs opens testdata/mksquashfs.sqfs, an archive made by mksquashfs instead of Writer.
+//See testdata/mksquashfs.sh for how it's made.
+func openMksquashfs(t *testing.T) *squashfs.Reader {
+	t.Helper()
+	fil, err := os.Open(filepath.Join("testdata", "mksquashfs.sqfs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fil.Close() })
+	rdr, err := squashfs.NewReader(fil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rdr
+}
+
+func TestMksquashfsXattrs(t *testing.T) {
+	rdr := openMksquashfs(t)
+	//user.shared's value is stored out of line.
+	shared := []byte("a value shared by two files")
+	for name, own := range map[string]string{"small": "small", "hardlink": "small", "big/file1": "file1"} {
+		got, err := rdr.Xattrs(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[string][]byte{"user.shared": shared, "user.own": []byte(own)}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got xattrs %q, want %q", name, got, want)
+		}
+		info, err := rdr.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err = info.Sys().(*squashfs.InodeInfo).Xattrs()
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got xattrs %q, %v from Sys, want %q", name, got, err, want)
+		}
+	}
+	got, err := rdr.Xattrs("big/file2")
+	if err != nil || len(got) != 0 {
+		t.Errorf("big/file2: got xattrs %q, %v, want none", got, err)
+	}
+}
+
+func TestMksquashfsCompressionOptions(t *testing.T) {
+	rdr := openMksquashfs(t)
+	info := rdr.Info()
+	if info.BlockSize != 4096 || info.Compression != squashfs.GZipCompression || !info.HasCompressionOptions {
+		t.Errorf("unexpected info: %+v", info)
+	}
+	if op, want := rdr.CompressionOptions(), (squashfs.GZipOptions{Level: 4, WindowSize: 12}); op != want {
+		t.Errorf("got compression options %+v, want %+v", op, want)
+	}
+	dat, err := rdr.ReadFile("small")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(dat) != "hello mksquashfs" {
+		t.Errorf("got %q, want %q", dat, "hello mksquashfs")
+	}
+}
+
+func TestMksquashfsSys(t *testing.T) {
+	rdr := openMksquashfs(t)
+	sys := func(name string) *squashfs.InodeInfo {
+		t.Helper()
+		info, err := rdr.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.Sys().(*squashfs.InodeInfo)
+	}
+	if small, hard := sys("small"), sys("hardlink"); small.Inode != hard.Inode || small.Nlink != 2 {
+		t.Errorf("hardlink: inode %d with %d links, want inode %d with 2 links", hard.Inode, small.Nlink, small.Inode)
+	}
+	if null := sys("null"); null.DevMajor != 1 || null.DevMinor != 3 || null.Uid != 1000 || null.Gid != 100 {
+		t.Errorf("null: unexpected info %+v", null)
+	}
+	if small := sys("small"); small.Uid != 0 || small.Gid != 0 {
+		t.Errorf("small: owned by %d:%d, want 0:0", small.Uid, small.Gid)
+	}
+	if root, big := sys("."), sys("big"); root.DirSize != 108 || big.DirSize != 14953 {
+		t.Errorf("directory sizes %d and %d, want 108 and 14953", root.DirSize, big.DirSize)
+	}
+}
+
+func TestMksquashfsDirectoryIndex(t *testing.T) {
+	rdr := openMksquashfs(t)
+	info, err := rdr.Stat("big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ := info.Sys().(*squashfs.InodeInfo).Type; typ != squashfs.EDirType {
+		t.Fatalf("big has type %d, want an extended directory with an index", typ)
+	}
+	for _, i := range []int{0, 1, 500, 998, 999} {
+		name := "big/file" + strconv.Itoa(i)
+		dat, err := rdr.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(dat) != strconv.Itoa(i) {
+			t.Errorf("%s: got %q, want %q", name, dat, strconv.Itoa(i))
+		}
+	}
+	for _, name := range []string{"big/file", "big/file1000", "big/file99a", "big/zzz"} {
+		_, err = rdr.Stat(name)
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s: got error %v, want %v", name, err, fs.ErrNotExist)
+		}
+	}
+	ents, err := rdr.ReadDir("big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ents) != 1000 {
+		t.Errorf("got %d entries in big, want 1000", len(ents))
+	}
+}
+
+func TestMksquashfsSparse(t *testing.T) {
+	rdr := openMksquashfs(t)
+	//Blocks 1 through 3, and 5 through 9, are sparse.
+	want := make([]byte, 40960)
+	copy(want, "start")
+	copy(want[16384:], "middle")
+	dat, err := rdr.ReadFile("sparse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dat, want) {
+		t.Errorf("data differs")
+	}
+	info, err := rdr.Stat("sparse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved := info.Sys().(*squashfs.InodeInfo).Sparse; saved != 8*4096 {
+		t.Errorf("%d bytes saved by sparse blocks, want %d", saved, 8*4096)
+	}
+	fil, err := rdr.Open("sparse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := make([]byte, 4096)
+	_, err = fil.(*squashfs.File).ReadAt(p, 14000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(p, want[14000:14000+4096]) {
+		t.Errorf("ReadAt returned the wrong data")
+	}
+}
+
+func TestMksquashfsCheck(t *testing.T) {
+	rdr := openMksquashfs(t)
+	if !rdr.Info().Exportable {
+		t.Fatal("archive isn't exportable")
+	}
+	//This includes resolving every entry in the export table.
+	findings, err := rdr.Check(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 0 {
+		t.Errorf("got findings %v, want none", findings)
+	}
+}
+
+func TestMksquashfsFileMode(t *testing.T) {
+	rdr := openMksquashfs(t)
+	modTime := time.Unix(1600000000, 0)
+	for name, want := range map[string]fs.FileMode{
+		"fifo":    fs.ModeNamedPipe | 0644,
+		"null":    fs.ModeDevice | fs.ModeCharDevice | 0666,
+		"symlink": fs.ModeSymlink | 0777,
+		"big":     fs.ModeDir | 0755,
+	} {
+		info, err := rdr.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode() != want {
+			t.Errorf("%s: mode %v, want %v", name, info.Mode(), want)
+		}
+		if !info.ModTime().Equal(modTime) {
+			t.Errorf("%s: mod time %v, want %v", name, info.ModTime(), modTime)
+		}
+	}
+	fil, err := rdr.Open("symlink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target := fil.(*squashfs.File).SymlinkPath(); target != "small" {
+		t.Errorf("symlink: target %q, want small", target)
+	}
+}
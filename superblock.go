@@ -1,6 +1,11 @@
 package squashfs
 
-import "math"
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/CalebQ42/squashfs/internal/toreader"
+)
 
 type superblock struct {
 	Magic            uint32
@@ -24,12 +29,36 @@ type superblock struct {
 	ExportTableStart uint64
 }
 
+//readSuperblock reads and validates the superblock at the beginning of r.
+func readSuperblock(r io.ReaderAt) (s superblock, err error) {
+	err = binary.Read(toreader.NewReader(r, 0), binary.LittleEndian, &s)
+	if err != nil {
+		return
+	}
+	if !s.checkMagic() {
+		return s, ErrorMagic
+	}
+	if !s.checkBlockLog() {
+		return s, ErrorLog
+	}
+	if !s.checkVersion() {
+		return s, ErrorVersion
+	}
+	return
+}
+
 func (s superblock) checkMagic() bool {
 	return s.Magic == 0x73717368
 }
 
+//checkBlockLog makes sure the block size matches the block log, and is between 4KiB and 1MiB.
 func (s superblock) checkBlockLog() bool {
-	return s.BlockLog == uint16(math.Log2(float64(s.BlockSize)))
+	return s.BlockSize >= 4096 && s.BlockSize <= 1<<20 && s.BlockLog < 32 && s.BlockSize == 1<<s.BlockLog
+}
+
+//fits returns if a table with count entries of the given size can fit in the archive.
+func (s superblock) fits(count, size uint64) bool {
+	return count*size <= s.Size
 }
 
 func (s superblock) checkVersion() bool {
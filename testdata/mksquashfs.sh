@@ -0,0 +1,36 @@
+#!/bin/sh
+#Builds mksquashfs.sqfs, an archive made by squashfs-tools' mksquashfs instead of this package's Writer.
+#Used by the TestMksquashfs tests to check the reader against another implementation. Made with mksquashfs 4.7.5.
+set -e
+MKSQUASHFS=${MKSQUASHFS:-mksquashfs}
+cd "$(dirname "$0")"
+src=$(mktemp -d)
+trap 'rm -rf "$src"' EXIT
+chmod 755 "$src"
+#Holes: blocks of zeros are stored as sparse blocks, including at the end of the file.
+printf 'start' > "$src/sparse"
+truncate -s 16384 "$src/sparse"
+printf 'middle' >> "$src/sparse"
+truncate -s 40960 "$src/sparse"
+#Directory index: big's listing is larger then a metadata block.
+mkdir "$src/big"
+i=0
+while [ $i -lt 1000 ]; do
+	printf '%d' $i > "$src/big/file$i"
+	i=$((i+1))
+done
+printf 'hello mksquashfs' > "$src/small"
+ln "$src/small" "$src/hardlink"
+ln -s small "$src/symlink"
+#-b 4096 makes sparse's zeros whole blocks, and the gzip options are written because they aren't the defaults.
+#The export table is made by default. The shared xattr value is long enough, and used enough, to be stored out of line.
+rm -f mksquashfs.sqfs
+"$MKSQUASHFS" "$src" mksquashfs.sqfs -noappend -no-progress -quiet \
+	-b 4096 -comp gzip -Xcompression-level 4 -Xwindow-size 12 \
+	-all-root -pseudo-override -all-time 1600000000 -mkfs-time 1600000000 \
+	-p 'fifo i 644 0 0 f' \
+	-p 'null c 666 1000 100 1 3' \
+	-p 'small x user.shared=a value shared by two files' \
+	-p 'small x user.own=small' \
+	-p 'big/file1 x user.shared=a value shared by two files' \
+	-p 'big/file1 x user.own=file1'
@@ -0,0 +1,172 @@
+package squashfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"math"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/CalebQ42/squashfs/internal/metadata"
+)
+
+//Writer creates a squashfs archive from a fs.FS.
+type Writer struct {
+	fsys fs.FS
+	//ModTime is the archive's modification time. If zero, the time WriteTo is called is used.
+	ModTime time.Time
+	//BlockSize is the size of data blocks. Must be a power of two between 4KiB and 1MiB.
+	BlockSize uint32
+	//CompressionOptions are stored in the archive and used to configure the compressor. Optional.
+	//If set, must match Compression.
+	CompressionOptions CompressionOptions
+	//Compression is the compression used for the archive. Must be one of the *Compression constants.
+	Compression uint16
+}
+
+//ReadLinkFS is a fs.FS that can report symlinks without following them.
+//If the fs.FS given to NewWriter implements it, symlinks are stored as symlinks. Otherwise they are followed.
+//This matches io/fs.ReadLinkFS from newer versions of Go.
+type ReadLinkFS interface {
+	fs.FS
+	ReadLink(name string) (string, error)
+	Lstat(name string) (fs.FileInfo, error)
+}
+
+var (
+	ErrBlockSize   = errors.New("block size must be a power of two between 4KiB and 1MiB")
+	ErrCompression = errors.New("unsupported compression type")
+)
+
+const (
+	DefaultBlockSize = uint32(128 * 1024)
+	minBlockSize     = uint32(4 * 1024)
+	maxBlockSize     = uint32(1024 * 1024)
+)
+
+//NewWriter creates a Writer that archives fsys, using gzip compression and a 128KiB block size.
+func NewWriter(fsys fs.FS) *Writer {
+	return &Writer{
+		fsys:        fsys,
+		BlockSize:   DefaultBlockSize,
+		Compression: GZipCompression,
+	}
+}
+
+//NewWriterFromPath creates a Writer that archives the directory at dir. Symlinks are preserved.
+func NewWriterFromPath(dir string) *Writer {
+	return NewWriter(dirFS(filepath.Clean(dir)))
+}
+
+//WriteTo writes the archive to w.
+//If w is an io.WriterAt (such as an *os.File), the archive is written directly. Otherwise the archive is built in a temporary file first.
+func (w *Writer) WriteTo(out io.Writer) (int64, error) {
+	if w.BlockSize < minBlockSize || w.BlockSize > maxBlockSize || bits.OnesCount32(w.BlockSize) != 1 {
+		return 0, ErrBlockSize
+	}
+	c, err := newCompressor(w.Compression, w.CompressionOptions)
+	if err != nil {
+		return 0, err
+	}
+	dest, ok := out.(io.WriterAt)
+	var tmp *os.File
+	if !ok {
+		tmp, err = os.CreateTemp("", "squashfs")
+		if err != nil {
+			return 0, err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		dest = tmp
+	}
+	modTime := w.ModTime
+	if modTime.IsZero() {
+		modTime = time.Now()
+	}
+	img := &imageWriter{
+		fsys:      w.fsys,
+		out:       dest,
+		c:         c,
+		blockSize: w.BlockSize,
+		off:       96,
+		idInd:     make(map[uint32]uint16),
+		links:     make(map[fileID]*writerNode),
+		xattrs: xattrWriter{
+			kv:     metadata.NewWriter(c),
+			sets:   make(map[string]uint32),
+			values: make(map[string]uint64),
+		},
+	}
+	if w.CompressionOptions != nil {
+		err = img.writeCompressionOptions(w.CompressionOptions)
+		if err != nil {
+			return 0, err
+		}
+	}
+	root, err := img.readTree()
+	if err != nil {
+		return 0, err
+	}
+	s, err := img.write(root)
+	if err != nil {
+		return 0, err
+	}
+	s.ModTime = squashTime(modTime)
+	s.BlockSize = w.BlockSize
+	s.BlockLog = uint16(bits.TrailingZeros32(w.BlockSize))
+	s.CompType = w.Compression
+	if w.CompressionOptions != nil {
+		s.Flags |= 0x400
+	}
+	var buf bytes.Buffer
+	err = binary.Write(&buf, binary.LittleEndian, s)
+	if err != nil {
+		return 0, err
+	}
+	_, err = dest.WriteAt(buf.Bytes(), 0)
+	if err != nil {
+		return 0, err
+	}
+	//Pad the archive to a multiple of 4KiB like mksquashfs.
+	size := int64(math.Ceil(float64(s.Size)/4096)) * 4096
+	if pad := size - int64(s.Size); pad > 0 {
+		_, err = dest.WriteAt(make([]byte, pad), int64(s.Size))
+		if err != nil {
+			return 0, err
+		}
+	}
+	if tmp == nil {
+		return size, nil
+	}
+	_, err = tmp.Seek(0, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	return io.Copy(out, tmp)
+}
+
+//dirFS is os.DirFS that also implements ReadLinkFS.
+type dirFS string
+
+func (d dirFS) Open(name string) (fs.File, error) {
+	return os.DirFS(string(d)).Open(name)
+}
+
+func (d dirFS) ReadLink(name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return os.Readlink(filepath.Join(string(d), filepath.FromSlash(name)))
+}
+
+func (d dirFS) Lstat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: fs.ErrInvalid}
+	}
+	return os.Lstat(filepath.Join(string(d), filepath.FromSlash(name)))
+}
@@ -0,0 +1,120 @@
+package squashfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/CalebQ42/squashfs/internal/inode"
+)
+
+func (w *imageWriter) writeRaw(dat []byte) error {
+	_, err := w.out.WriteAt(dat, int64(w.off))
+	w.off += uint64(len(dat))
+	return err
+}
+
+//writeCompressionOptions writes the compression options as an uncompressed metadata block.
+func (w *imageWriter) writeCompressionOptions(op CompressionOptions) error {
+	var buf bytes.Buffer
+	err := binary.Write(&buf, binary.LittleEndian, op)
+	if err != nil {
+		return err
+	}
+	head := make([]byte, 2)
+	binary.LittleEndian.PutUint16(head, uint16(buf.Len())|0x8000)
+	return w.writeRaw(append(head, buf.Bytes()...))
+}
+
+//writeBlock compresses and writes a data or fragment block and returns it's size as it's stored in the archive.
+func (w *imageWriter) writeBlock(dat []byte) (uint32, error) {
+	comp, err := w.c.Compress(dat)
+	if err != nil {
+		return 0, err
+	}
+	size := uint32(len(comp))
+	if len(comp) >= len(dat) {
+		comp = dat
+		size = uint32(len(dat)) | (1 << 24)
+	}
+	return size, w.writeRaw(comp)
+}
+
+func (w *imageWriter) flushFragment() error {
+	if len(w.frag) == 0 {
+		return nil
+	}
+	start := w.off
+	size, err := w.writeBlock(w.frag)
+	if err != nil {
+		return err
+	}
+	w.fragEntries = append(w.fragEntries, fragEntry{
+		Start: start,
+		Size:  size,
+	})
+	w.frag = w.frag[:0]
+	return nil
+}
+
+func (w *imageWriter) addFragment(n *writerNode, tail []byte) error {
+	if len(w.frag)+len(tail) > int(w.blockSize) {
+		err := w.flushFragment()
+		if err != nil {
+			return err
+		}
+	}
+	n.fragInd = uint32(len(w.fragEntries))
+	n.fragOffset = uint32(len(w.frag))
+	w.frag = append(w.frag, tail...)
+	return nil
+}
+
+//writeData writes the data blocks of every regular file, packing the ends of files into fragments.
+func (w *imageWriter) writeData() error {
+	buf := make([]byte, w.blockSize)
+	zero := make([]byte, w.blockSize)
+	for _, n := range w.nodes {
+		if n.typ != inode.Fil {
+			continue
+		}
+		err := w.writeFile(n, buf, zero)
+		if err != nil {
+			return err
+		}
+	}
+	return w.flushFragment()
+}
+
+func (w *imageWriter) writeFile(n *writerNode, buf, zero []byte) error {
+	fil, err := w.fsys.Open(n.path)
+	if err != nil {
+		return err
+	}
+	defer fil.Close()
+	n.blockStart = w.off
+	var read int
+	var size uint32
+	for {
+		read, err = io.ReadFull(fil, buf)
+		n.size += uint64(read)
+		if err == io.EOF {
+			return nil
+		} else if err == io.ErrUnexpectedEOF {
+			return w.addFragment(n, buf[:read])
+		} else if err != nil {
+			return err
+		}
+		//Blocks of all zeros are stored as holes.
+		if bytes.Equal(buf, zero) {
+			n.blockSizes = append(n.blockSizes, 0)
+			n.sparse += uint64(read)
+			continue
+		}
+		size, err = w.writeBlock(buf)
+		if err != nil {
+			return err
+		}
+		n.blockSizes = append(n.blockSizes, size)
+	}
+}
@@ -0,0 +1,58 @@
+package squashfs
+
+import (
+	"io/fs"
+	"strings"
+	"syscall"
+)
+
+//getSysInfo gets ownership, device, and hard link info from a fs.FileInfo if it's from the os package.
+func getSysInfo(info fs.FileInfo) (s sysInfo) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+	major := uint32((st.Rdev>>8)&0xfff) | uint32((st.Rdev>>32)&^0xfff)
+	minor := uint32(st.Rdev&0xff) | uint32((st.Rdev>>12)&^0xff)
+	return sysInfo{
+		id: fileID{
+			dev: uint64(st.Dev),
+			ino: uint64(st.Ino),
+		},
+		hasID: true,
+		uid:   st.Uid,
+		gid:   st.Gid,
+		nlink: uint32(st.Nlink),
+		rdev:  encodeDev(major, minor),
+	}
+}
+
+//getXattrs reads the extended attributes of the file at path. Symlinks are followed.
+func getXattrs(path string) (map[string][]byte, error) {
+	size, err := syscall.Listxattr(path, nil)
+	if err == syscall.ENOTSUP || size == 0 {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	list := make([]byte, size)
+	size, err = syscall.Listxattr(path, list)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string][]byte)
+	var val []byte
+	for _, name := range strings.Split(strings.TrimSuffix(string(list[:size]), "\x00"), "\x00") {
+		size, err = syscall.Getxattr(path, name, nil)
+		if err != nil {
+			return nil, err
+		}
+		val = make([]byte, size)
+		size, err = syscall.Getxattr(path, name, val)
+		if err != nil {
+			return nil, err
+		}
+		out[name] = val[:size]
+	}
+	return out, nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package squashfs
+
+import "io/fs"
+
+//getSysInfo gets ownership, device, and hard link info from a fs.FileInfo. Only supported on Linux.
+func getSysInfo(fs.FileInfo) (s sysInfo) {
+	return
+}
+
+//getXattrs reads the extended attributes of the file at path. Only supported on Linux.
+func getXattrs(string) (map[string][]byte, error) {
+	return nil, nil
+}
@@ -0,0 +1,288 @@
+package squashfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/fs"
+	"math"
+	"time"
+
+	"github.com/CalebQ42/squashfs/internal/directory"
+	"github.com/CalebQ42/squashfs/internal/inode"
+	"github.com/CalebQ42/squashfs/internal/metadata"
+)
+
+//write writes the data, inode table, directory table, and lookup tables.
+//Returns the superblock, minus the fields that come directly from the Writer.
+func (w *imageWriter) write(root *writerNode) (s superblock, err error) {
+	err = w.writeData()
+	if err != nil {
+		return
+	}
+	inodes := metadata.NewWriter(w.c)
+	dirs := metadata.NewWriter(w.c)
+	export := make([]uint64, len(w.nodes))
+	err = w.writeInode(root, inodes, dirs, export)
+	if err != nil {
+		return
+	}
+	s = superblock{
+		Magic:        0x73717368,
+		InodeCount:   uint32(len(w.nodes)),
+		FragCount:    uint32(len(w.fragEntries)),
+		IdCount:      uint16(len(w.ids)),
+		VerMaj:       4,
+		RootInodeRef: root.ref,
+		//Exportable and no xattrs
+		Flags:           0x80 | 0x200,
+		XattrTableStart: math.MaxUint64,
+	}
+	dat, err := inodes.Bytes()
+	if err != nil {
+		return
+	}
+	s.InodeTableStart = w.off
+	err = w.writeRaw(dat)
+	if err != nil {
+		return
+	}
+	dat, err = dirs.Bytes()
+	if err != nil {
+		return
+	}
+	s.DirTableStart = w.off
+	err = w.writeRaw(dat)
+	if err != nil {
+		return
+	}
+	s.FragTableStart, err = w.writeTable(w.fragEntries)
+	if err != nil {
+		return
+	}
+	s.ExportTableStart, err = w.writeTable(export)
+	if err != nil {
+		return
+	}
+	s.IdTableStart, err = w.writeTable(w.ids)
+	if err != nil {
+		return
+	}
+	if len(w.xattrs.ids) > 0 {
+		s.Flags &^= 0x200
+		s.XattrTableStart, err = w.writeXattrs()
+		if err != nil {
+			return
+		}
+	}
+	s.Size = w.off
+	return
+}
+
+//writeTable writes a lookup table (such as the id table) as metadata blocks followed by a list of the block's locations.
+//Returns the location of the list.
+func (w *imageWriter) writeTable(data any) (uint64, error) {
+	meta := metadata.NewWriter(w.c)
+	err := binary.Write(meta, binary.LittleEndian, data)
+	if err != nil {
+		return 0, err
+	}
+	dat, err := meta.Bytes()
+	if err != nil {
+		return 0, err
+	}
+	tableStart := w.off
+	err = w.writeRaw(dat)
+	if err != nil {
+		return 0, err
+	}
+	offsets := make([]uint64, meta.Blocks())
+	for i := range offsets {
+		offsets[i] = tableStart + uint64(meta.BlockStart(i))
+	}
+	start := w.off
+	var buf bytes.Buffer
+	err = binary.Write(&buf, binary.LittleEndian, offsets)
+	if err != nil {
+		return 0, err
+	}
+	return start, w.writeRaw(buf.Bytes())
+}
+
+//squashPerm converts a fs.FileMode's permission bits to their unix values.
+func squashPerm(m fs.FileMode) uint16 {
+	perm := uint16(m.Perm())
+	if m&fs.ModeSetuid == fs.ModeSetuid {
+		perm |= 04000
+	}
+	if m&fs.ModeSetgid == fs.ModeSetgid {
+		perm |= 02000
+	}
+	if m&fs.ModeSticky == fs.ModeSticky {
+		perm |= 01000
+	}
+	return perm
+}
+
+//squashTime converts t to the unsigned 32 bit time used by squashfs. Times outside of it's range are clamped.
+func squashTime(t time.Time) uint32 {
+	if t.Unix() < 0 {
+		return 0
+	} else if t.Unix() > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(t.Unix())
+}
+
+//writeInode writes n's inode. If n is a directory, all of it's children's inodes and it's directory listing are written first.
+func (w *imageWriter) writeInode(n *writerNode, inodes, dirs *metadata.Writer, export []uint64) (err error) {
+	if n.written {
+		return nil
+	}
+	typ := n.typ
+	var dat any
+	switch n.typ {
+	case inode.Dir:
+		ents := make([]directory.Entry, len(n.children))
+		subdirs := uint32(0)
+		for i, c := range n.children {
+			if c.link != nil {
+				c = c.link
+			}
+			err = w.writeInode(c, inodes, dirs, export)
+			if err != nil {
+				return
+			}
+			if c.typ == inode.Dir {
+				subdirs++
+			}
+			ents[i] = directory.Entry{
+				Name:       n.children[i].name,
+				BlockStart: uint32(c.ref >> 16),
+				Offset:     uint16(c.ref),
+				Num:        c.num,
+				Type:       c.typ,
+			}
+		}
+		start := dirs.Len()
+		blockStart, offset := dirs.Ref()
+		listing, idx := directory.WriteEntries(ents, uint32(start), metadata.BlockSize)
+		_, err = dirs.Write(listing)
+		if err != nil {
+			return
+		}
+		//The root directory's parent is one past the last inode.
+		parent := uint32(len(w.nodes) + 1)
+		if n.parent != nil {
+			parent = n.parent.num
+		}
+		//Directory sizes are three larger then the actual listing.
+		size := uint32(len(listing) + 3)
+		if size > math.MaxUint16 || len(idx) > 0 || n.xattrInd != 0xFFFFFFFF {
+			typ = inode.EDir
+			var d inode.EDirectory
+			d.LinkCount = 2 + subdirs
+			d.Size = size
+			d.BlockStart = blockStart
+			d.ParentNum = parent
+			d.Offset = offset
+			d.XattrInd = n.xattrInd
+			d.Indexes = make([]inode.DirectoryIndex, len(idx))
+			for i := range idx {
+				d.Indexes[i].Ind = idx[i].Ind
+				d.Indexes[i].Start = dirs.BlockStart(int((uint32(start) + idx[i].Ind) / metadata.BlockSize))
+				d.Indexes[i].Name = []byte(idx[i].Name)
+			}
+			dat = d
+		} else {
+			dat = inode.Directory{
+				BlockStart: blockStart,
+				LinkCount:  2 + subdirs,
+				Size:       uint16(size),
+				Offset:     offset,
+				ParentNum:  parent,
+			}
+		}
+	case inode.Fil:
+		if n.size > math.MaxUint32 || n.blockStart > math.MaxUint32 || n.sparse > 0 || n.nlink > 1 || n.xattrInd != 0xFFFFFFFF {
+			typ = inode.EFil
+			var f inode.EFile
+			f.BlockStart = n.blockStart
+			f.Size = n.size
+			f.Sparse = n.sparse
+			f.LinkCount = n.nlink
+			f.FragInd = n.fragInd
+			f.Offset = n.fragOffset
+			f.XattrInd = n.xattrInd
+			f.BlockSizes = n.blockSizes
+			dat = f
+		} else {
+			var f inode.File
+			f.BlockStart = uint32(n.blockStart)
+			f.FragInd = n.fragInd
+			f.Offset = n.fragOffset
+			f.Size = uint32(n.size)
+			f.BlockSizes = n.blockSizes
+			dat = f
+		}
+	case inode.Sym:
+		if n.xattrInd != 0xFFFFFFFF {
+			typ = inode.ESym
+			var s inode.ESymlink
+			s.LinkCount = n.nlink
+			s.Target = []byte(n.target)
+			s.XattrInd = n.xattrInd
+			dat = s
+		} else {
+			var s inode.Symlink
+			s.LinkCount = n.nlink
+			s.Target = []byte(n.target)
+			dat = s
+		}
+	case inode.Block, inode.Char:
+		d := inode.Device{
+			LinkCount: n.nlink,
+			Dev:       n.dev,
+		}
+		if n.xattrInd != 0xFFFFFFFF {
+			typ += inode.EDir - inode.Dir
+			dat = inode.EDevice{
+				Device:   d,
+				XattrInd: n.xattrInd,
+			}
+		} else {
+			dat = d
+		}
+	case inode.Fifo, inode.Sock:
+		i := inode.IPC{
+			LinkCount: n.nlink,
+		}
+		if n.xattrInd != 0xFFFFFFFF {
+			typ += inode.EDir - inode.Dir
+			dat = inode.EIPC{
+				IPC:      i,
+				XattrInd: n.xattrInd,
+			}
+		} else {
+			dat = i
+		}
+	}
+	blockStart, offset := inodes.Ref()
+	n.ref = uint64(blockStart)<<16 | uint64(offset)
+	err = inode.Write(inodes, inode.Inode{
+		Header: inode.Header{
+			Type:    typ,
+			Perm:    squashPerm(n.info.Mode()),
+			UidInd:  n.uidInd,
+			GidInd:  n.gidInd,
+			ModTime: squashTime(n.info.ModTime()),
+			Num:     n.num,
+		},
+		Data: dat,
+	})
+	if err != nil {
+		return
+	}
+	export[n.num-1] = n.ref
+	n.written = true
+	return
+}
@@ -0,0 +1,199 @@
+package squashfs_test
+
+import (
+	"bytes"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/CalebQ42/squashfs"
+)
+
+//testFS returns a fs.FS with a bit of everything that the Writer supports.
+func testFS() fstest.MapFS {
+	modTime := time.Unix(1600000000, 0)
+	rnd := rand.New(rand.NewSource(42))
+	random := make([]byte, 300*1024)
+	rnd.Read(random)
+	sparse := make([]byte, 512*1024)
+	copy(sparse[400*1024:], random[:1000])
+	out := fstest.MapFS{
+		"empty":             {Mode: 0644, ModTime: modTime},
+		"small.txt":         {Data: []byte("hello squashfs"), Mode: 0600, ModTime: modTime},
+		"random.bin":        {Data: random, Mode: 0755, ModTime: modTime},
+		"sparse.bin":        {Data: sparse, Mode: 0644, ModTime: modTime},
+		"dir/sub/deep.txt":  {Data: bytes.Repeat([]byte("deep "), 10000), Mode: 0644, ModTime: modTime},
+		"dir/link":          {Data: []byte("sub/deep.txt"), Mode: fs.ModeSymlink | 0777, ModTime: modTime},
+		"dir/emptydir":      {Mode: fs.ModeDir | 0700, ModTime: modTime},
+		"dir/setuid":        {Data: []byte("suid"), Mode: fs.ModeSetuid | 0755, ModTime: modTime},
+		"dir/sub/other.txt": {Data: []byte("other"), Mode: 0644, ModTime: modTime},
+	}
+	for i := 0; i < 600; i++ {
+		out["many/file"+strconv.Itoa(i)] = &fstest.MapFile{Data: []byte(strconv.Itoa(i)), Mode: 0644, ModTime: modTime}
+	}
+	return out
+}
+
+func compareFS(t *testing.T, want fstest.MapFS, got *squashfs.Reader) {
+	t.Helper()
+	err := fs.WalkDir(want, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		wantInfo, err := d.Info()
+		if err != nil {
+			return err
+		}
+		gotInfo, err := got.Stat(path)
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			return nil
+		}
+		if path != "." && wantInfo.Mode().Perm() != gotInfo.Mode().Perm() {
+			t.Errorf("%s: mode %v, want %v", path, gotInfo.Mode(), wantInfo.Mode())
+		}
+		if !d.IsDir() && !wantInfo.ModTime().Equal(gotInfo.ModTime()) {
+			t.Errorf("%s: mod time %v, want %v", path, gotInfo.ModTime(), wantInfo.ModTime())
+		}
+		if d.Type()&fs.ModeSymlink == fs.ModeSymlink {
+			fil, err := got.Open(path)
+			if err != nil {
+				t.Errorf("%s: %v", path, err)
+				return nil
+			}
+			wantTarget := want[path].Data
+			if target := fil.(*squashfs.File).SymlinkPath(); target != string(wantTarget) {
+				t.Errorf("%s: symlink target %q, want %q", path, target, wantTarget)
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if !gotInfo.IsDir() {
+				t.Errorf("%s: not a directory", path)
+			}
+			return nil
+		}
+		wantDat, err := fs.ReadFile(want, path)
+		if err != nil {
+			return err
+		}
+		gotDat, err := got.ReadFile(path)
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			return nil
+		}
+		if !bytes.Equal(wantDat, gotDat) {
+			t.Errorf("%s: data differs (got %d bytes, want %d)", path, len(gotDat), len(wantDat))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+//writeArchive writes w to memory and returns the archive.
+func writeArchive(t testing.TB, w *squashfs.Writer) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	_, err := w.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+//readArchive opens an archive made by writeArchive.
+func readArchive(t testing.TB, dat []byte) *squashfs.Reader {
+	t.Helper()
+	rdr, err := squashfs.NewReader(bytes.NewReader(dat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rdr
+}
+
+//buildArchive writes src as an archive with the Writer's defaults and opens it.
+func buildArchive(t testing.TB, src fs.FS) *squashfs.Reader {
+	t.Helper()
+	return readArchive(t, writeArchive(t, squashfs.NewWriter(src)))
+}
+
+func TestWriter(t *testing.T) {
+	src := testFS()
+	comps := map[string]uint16{
+		"gzip": squashfs.GZipCompression,
+		"lzma": squashfs.LZMACompression,
+		"lzo":  squashfs.LZOCompression,
+		"xz":   squashfs.XZCompression,
+		"lz4":  squashfs.LZ4Compression,
+		"zstd": squashfs.ZSTDCompression,
+	}
+	for name, comp := range comps {
+		comp := comp
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			w := squashfs.NewWriter(src)
+			w.Compression = comp
+			w.BlockSize = 64 * 1024
+			var buf bytes.Buffer
+			_, err := w.WriteTo(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rdr, err := squashfs.NewReader(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			compareFS(t, src, rdr)
+		})
+	}
+}
+
+func TestWriterFromPath(t *testing.T) {
+	dir := t.TempDir()
+	err := os.MkdirAll(filepath.Join(dir, "a", "b"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "a", "b", "file"), []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Symlink("b/file", filepath.Join(dir, "a", "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.Create(filepath.Join(t.TempDir(), "out.sfs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	_, err = squashfs.NewWriterFromPath(dir).WriteTo(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdr, err := squashfs.NewReader(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fil, err := rdr.Open("a/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target := fil.(*squashfs.File).SymlinkPath(); target != "b/file" {
+		t.Fatalf("symlink target %q, want b/file", target)
+	}
+	dat, err := rdr.ReadFile("a/b/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(dat) != "data" {
+		t.Fatalf("file data %q, want data", dat)
+	}
+}
@@ -0,0 +1,218 @@
+package squashfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"math"
+	"path"
+	"sort"
+
+	"github.com/CalebQ42/squashfs/internal/compress"
+	"github.com/CalebQ42/squashfs/internal/inode"
+)
+
+//imageWriter holds the state of a single Writer.WriteTo call.
+type imageWriter struct {
+	fsys        fs.FS
+	out         io.WriterAt
+	c           compress.Compressor
+	links       map[fileID]*writerNode
+	idInd       map[uint32]uint16
+	xattrs      xattrWriter
+	frag        []byte
+	ids         []uint32
+	fragEntries []fragEntry
+	nodes       []*writerNode
+	blockSize   uint32
+	off         uint64
+}
+
+//fileID identifies a file on the source filesystem so hard links can be found.
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+//writerNode is a single file in the archive being written.
+type writerNode struct {
+	info       fs.FileInfo
+	link       *writerNode
+	parent     *writerNode
+	name       string
+	path       string
+	target     string
+	children   []*writerNode
+	blockSizes []uint32
+	blockStart uint64
+	size       uint64
+	sparse     uint64
+	ref        uint64
+	dev        uint32
+	num        uint32
+	nlink      uint32
+	fragInd    uint32
+	fragOffset uint32
+	xattrInd   uint32
+	uidInd     uint16
+	gidInd     uint16
+	typ        uint16
+	written    bool
+}
+
+func (w *imageWriter) lstat(name string) (fs.FileInfo, error) {
+	if rl, ok := w.fsys.(ReadLinkFS); ok {
+		return rl.Lstat(name)
+	}
+	return fs.Stat(w.fsys, name)
+}
+
+func (w *imageWriter) id(id uint32) uint16 {
+	if ind, ok := w.idInd[id]; ok {
+		return ind
+	}
+	ind := uint16(len(w.ids))
+	w.idInd[id] = ind
+	w.ids = append(w.ids, id)
+	return ind
+}
+
+//readTree reads the entire source filesystem and assigns inode numbers.
+func (w *imageWriter) readTree() (*writerNode, error) {
+	info, err := fs.Stat(w.fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, errors.New("root of the fs.FS is not a directory")
+	}
+	root, err := w.newNode(".", "", info, nil)
+	if err != nil {
+		return nil, err
+	}
+	//Inode numbers are assigned breadth first so that each directory's children have close numbers.
+	queue := []*writerNode{root}
+	var n *writerNode
+	for len(queue) > 0 {
+		n, queue = queue[0], queue[1:]
+		if n.link != nil {
+			n.link.nlink++
+			continue
+		}
+		w.nodes = append(w.nodes, n)
+		n.num = uint32(len(w.nodes))
+		queue = append(queue, n.children...)
+	}
+	if len(w.ids) > math.MaxUint16 {
+		return nil, errors.New("too many unique uids and gids")
+	}
+	return root, nil
+}
+
+func (w *imageWriter) newNode(name, base string, info fs.FileInfo, parent *writerNode) (n *writerNode, err error) {
+	n = &writerNode{
+		info:     info,
+		parent:   parent,
+		name:     base,
+		path:     name,
+		nlink:    1,
+		fragInd:  0xFFFFFFFF,
+		xattrInd: 0xFFFFFFFF,
+	}
+	sys := getSysInfo(info)
+	n.uidInd = w.id(sys.uid)
+	n.gidInd = w.id(sys.gid)
+	n.dev = sys.rdev
+	if xfs, ok := w.fsys.(XattrFS); ok && info.Mode().Type() != fs.ModeSymlink {
+		var attrs map[string][]byte
+		attrs, err = xfs.Xattrs(name)
+		if err != nil {
+			return
+		}
+		n.xattrInd, err = w.xattrs.add(attrs)
+		if err != nil {
+			return
+		}
+	}
+	switch info.Mode().Type() {
+	case fs.ModeDir:
+		n.typ = inode.Dir
+		var ents []fs.DirEntry
+		ents, err = fs.ReadDir(w.fsys, name)
+		if err != nil {
+			return
+		}
+		//squashfs requires directory entries be sorted.
+		sort.Slice(ents, func(i, j int) bool {
+			return ents[i].Name() < ents[j].Name()
+		})
+		var childInfo fs.FileInfo
+		var child *writerNode
+		for _, e := range ents {
+			childPath := path.Join(name, e.Name())
+			childInfo, err = w.lstat(childPath)
+			if err != nil {
+				return
+			}
+			child, err = w.newNode(childPath, e.Name(), childInfo, n)
+			if err != nil {
+				return
+			}
+			n.children = append(n.children, child)
+		}
+		return
+	case fs.ModeSymlink:
+		n.typ = inode.Sym
+		rl, ok := w.fsys.(ReadLinkFS)
+		if !ok {
+			return nil, &fs.PathError{
+				Op:   "readlink",
+				Path: name,
+				Err:  errors.New("fs.FS does not implement ReadLinkFS"),
+			}
+		}
+		n.target, err = rl.ReadLink(name)
+		if err != nil {
+			return
+		}
+	case fs.ModeDevice:
+		n.typ = inode.Block
+	case fs.ModeDevice | fs.ModeCharDevice:
+		n.typ = inode.Char
+	case fs.ModeNamedPipe:
+		n.typ = inode.Fifo
+	case fs.ModeSocket:
+		n.typ = inode.Sock
+	case 0:
+		n.typ = inode.Fil
+	default:
+		return nil, &fs.PathError{
+			Op:   "write",
+			Path: name,
+			Err:  errors.New("unsupported file type"),
+		}
+	}
+	if sys.hasID && sys.nlink > 1 {
+		if first, ok := w.links[sys.id]; ok {
+			n.link = first
+		} else {
+			w.links[sys.id] = n
+		}
+	}
+	return
+}
+
+//sysInfo is the information about a file that isn't available from fs.FileInfo.
+type sysInfo struct {
+	id    fileID
+	uid   uint32
+	gid   uint32
+	nlink uint32
+	rdev  uint32
+	hasID bool
+}
+
+//encodeDev encodes a device number the same way as Linux's new_encode_dev.
+func encodeDev(major, minor uint32) uint32 {
+	return (minor & 0xff) | (major << 8) | ((minor &^ 0xff) << 12)
+}
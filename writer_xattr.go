@@ -0,0 +1,135 @@
+package squashfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/fs"
+	"sort"
+
+	"github.com/CalebQ42/squashfs/internal/metadata"
+	"github.com/CalebQ42/squashfs/internal/xattr"
+)
+
+//XattrFS is a fs.FS that can provide extended attributes.
+//If the fs.FS given to NewWriter implements it, the extended attributes are added to the archive.
+//Only the user, trusted, and security namespaces are supported. Others are ignored.
+type XattrFS interface {
+	fs.FS
+	Xattrs(name string) (map[string][]byte, error)
+}
+
+func (d dirFS) Xattrs(name string) (map[string][]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "xattrs", Path: name, Err: fs.ErrInvalid}
+	}
+	return getXattrs(string(d) + "/" + name)
+}
+
+//xattrWriter builds the xattr tables.
+type xattrWriter struct {
+	kv     *metadata.Writer
+	sets   map[string]uint32
+	values map[string]uint64
+	ids    []xattr.ID
+}
+
+//add adds a set of extended attributes and returns it's index in the xattr id table.
+//Identical sets are only stored once, and values that have already been stored are referenced instead of being stored again.
+func (x *xattrWriter) add(attrs map[string][]byte) (uint32, error) {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		if _, _, ok := xattr.Split(name); ok {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return 0xFFFFFFFF, nil
+	}
+	sort.Strings(names)
+	var key bytes.Buffer
+	for _, name := range names {
+		binary.Write(&key, binary.LittleEndian, uint32(len(name)))
+		key.WriteString(name)
+		binary.Write(&key, binary.LittleEndian, uint32(len(attrs[name])))
+		key.Write(attrs[name])
+	}
+	if ind, ok := x.sets[key.String()]; ok {
+		return ind, nil
+	}
+	block, offset := x.kv.Ref()
+	id := xattr.ID{
+		Ref:   uint64(block)<<16 | uint64(offset),
+		Count: uint32(len(names)),
+	}
+	var err error
+	for _, name := range names {
+		p := xattr.Pair{
+			Name:  name,
+			Value: attrs[name],
+		}
+		_, short, _ := xattr.Split(name)
+		id.Size += uint32(len(short) + len(p.Value))
+		if ref, ok := x.values[string(p.Value)]; ok {
+			p.OOL = true
+			p.Ref = ref
+			err = xattr.Write(x.kv, p)
+		} else {
+			err = xattr.WriteKey(x.kv, name, false)
+			if err != nil {
+				return 0, err
+			}
+			block, offset = x.kv.Ref()
+			if len(p.Value) > 8 {
+				x.values[string(p.Value)] = uint64(block)<<16 | uint64(offset)
+			}
+			err = xattr.WriteValue(x.kv, p.Value)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	ind := uint32(len(x.ids))
+	x.sets[key.String()] = ind
+	x.ids = append(x.ids, id)
+	return ind, nil
+}
+
+//writeXattrs writes the xattr tables and returns the location of the xattr id table.
+func (w *imageWriter) writeXattrs() (uint64, error) {
+	dat, err := w.xattrs.kv.Bytes()
+	if err != nil {
+		return 0, err
+	}
+	kvStart := w.off
+	err = w.writeRaw(dat)
+	if err != nil {
+		return 0, err
+	}
+	meta := metadata.NewWriter(w.c)
+	err = binary.Write(meta, binary.LittleEndian, w.xattrs.ids)
+	if err != nil {
+		return 0, err
+	}
+	dat, err = meta.Bytes()
+	if err != nil {
+		return 0, err
+	}
+	idStart := w.off
+	err = w.writeRaw(dat)
+	if err != nil {
+		return 0, err
+	}
+	var buf bytes.Buffer
+	err = binary.Write(&buf, binary.LittleEndian, xattr.IDTable{
+		Start: kvStart,
+		Count: uint32(len(w.xattrs.ids)),
+	})
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < meta.Blocks(); i++ {
+		binary.Write(&buf, binary.LittleEndian, idStart+uint64(meta.BlockStart(i)))
+	}
+	start := w.off
+	return start, w.writeRaw(buf.Bytes())
+}